@@ -1,7 +1,32 @@
 package main
 
-import "github.com/alexch365/go-url-shortener/internal/app"
+import (
+	"fmt"
+	"os"
+
+	"github.com/alexch365/go-url-shortener/internal/app"
+)
 
 func main() {
-	app.Run()
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var err error
+	switch command {
+	case "keys":
+		err = app.RunKeys(os.Args[2:])
+	case "export":
+		err = app.RunExport(os.Args[2:])
+	case "import":
+		err = app.RunImport(os.Args[2:])
+	default:
+		err = app.Run(os.Args[1:])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "shortener failed:", err)
+		os.Exit(1)
+	}
 }
@@ -1,62 +1,364 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/alexch365/go-url-shortener/internal/auth"
 	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/geoip"
+	"github.com/alexch365/go-url-shortener/internal/grpcapi"
 	"github.com/alexch365/go-url-shortener/internal/handlers"
 	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/openapi"
+	"github.com/alexch365/go-url-shortener/internal/preview"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/proxy"
+	"github.com/alexch365/go-url-shortener/internal/ratelimit"
 	"github.com/alexch365/go-url-shortener/internal/storage"
-	"github.com/caarlos0/env"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
+	"github.com/alexch365/go-url-shortener/internal/validate"
 	"github.com/go-chi/chi/v5"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func router() chi.Router {
+const shutdownTimeout = 10 * time.Second
+
+type App struct {
+	Config    *config.Source
+	Log       *logger.Logger
+	Store     storage.StoreHandler
+	Limiter   ratelimit.Backend
+	Validator *validate.Validator
+	Domains   *domains.Registry
+	Proxies   *proxy.Trusted
+	GeoIP     *geoip.Locator
+	Preview   *preview.Fetcher
+
+	spec *openapi.Spec
+	// limiters are shared by the HTTP routes and the gRPC methods.
+	limiters map[string]*ratelimit.Limiter
+}
+
+func New(cfg *config.Source, log *logger.Logger, store storage.StoreHandler) (*App, error) {
+	limiter, err := rateLimitBackend(cfg.Get(), store)
+	if err != nil {
+		return nil, fmt.Errorf("initialize rate limiter: %w", err)
+	}
+
+	registry, err := domains.New(cfg.Get().BaseURL, cfg.Get().PathPrefix, cfg.Get().Domains)
+	if err != nil {
+		return nil, fmt.Errorf("initialize domains: %w", err)
+	}
+
+	proxies, err := proxy.New(cfg.Get().TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("initialize trusted proxies: %w", err)
+	}
+
+	locator, err := geoip.NewLocator(cfg.Get().GeoIPDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("load geoip database: %w", err)
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load openapi spec: %w", err)
+	}
+
+	a := &App{
+		Config:    cfg,
+		Log:       log,
+		Store:     store,
+		Limiter:   limiter,
+		Validator: validate.New(cfg.Get().Blocklist),
+		Domains:   registry,
+		Proxies:   proxies,
+		GeoIP:     locator,
+		Preview:   preview.NewFetcher(),
+		spec:      spec,
+		limiters: map[string]*ratelimit.Limiter{
+			"create":   ratelimit.NewLimiter(limiter, createLimit(cfg.Get())),
+			"redirect": ratelimit.NewLimiter(limiter, redirectLimit(cfg.Get())),
+		},
+	}
+	cfg.Subscribe(func() {
+		a.limiters["create"].SetLimit(createLimit(cfg.Get()))
+		a.limiters["redirect"].SetLimit(redirectLimit(cfg.Get()))
+		log.Reload(cfg.Get())
+		a.Validator.SetBlocklist(cfg.Get().Blocklist)
+		if err := a.Domains.Set(cfg.Get().Domains); err != nil {
+			log.Errorw("apply domains failed", "error", err)
+		}
+		if err := a.Proxies.Set(cfg.Get().TrustedProxies); err != nil {
+			log.Errorw("apply trusted proxies failed", "error", err)
+		}
+		if err := a.GeoIP.Load(cfg.Get().GeoIPDatabase); err != nil {
+			log.Errorw("load geoip database failed", "error", err)
+		}
+	})
+	return a, nil
+}
+
+func (a *App) Router() chi.Router {
+	h := &handlers.Handler{Store: a.Store, Config: a.Config, Validator: a.Validator, Domains: a.Domains, GeoIP: a.GeoIP,
+		Preview: a.Preview, CreateLimit: a.limiters["create"]}
+	createLimit, redirectLimit := a.limiters["create"].Middleware, a.limiters["redirect"].Middleware
+
 	r := chi.NewRouter()
-	r.Use(logger.Middleware)
+	r.Use(tracing.Middleware)
+	r.Use(logger.RequestIDMiddleware)
+	r.Use(a.Log.Middleware)
 	r.Use(gzipMiddleware)
+	r.Use(auth.Middleware(a.Store, a.Config))
+	r.Use(a.spec.Middleware)
 
 	r.Route("/", func(r chi.Router) {
-		r.Get("/ping", handlers.PingDatabase)
-		r.Post("/", handlers.Shorten)
-		r.Post("/api/shorten", handlers.ShortenAPI)
-		r.Post("/api/shorten/batch", handlers.ShortenAPIBatch)
+		r.Get("/api/openapi.json", a.spec.ServeHTTP)
+		r.Get("/ping", h.PingDatabase)
+		r.Get("/healthz", h.Liveness)
+		r.Get("/readyz", h.Readiness)
+		r.With(createLimit, auth.RequireScope(auth.ScopeShorten)).Post("/", h.Shorten)
+		r.With(createLimit, auth.RequireScope(auth.ScopeShorten)).Post("/api/shorten", h.ShortenAPI)
+		r.With(auth.RequireScope(auth.ScopeBatch)).Post("/api/shorten/batch", h.ShortenAPIBatch)
+		r.With(redirectLimit).Get("/api/qr/{id}", h.QRCode)
+		r.With(auth.RequireScope(auth.ScopeStats)).Get("/api/user/urls", h.UserURLs)
+		r.With(auth.RequireScope(auth.ScopeDelete)).Delete("/api/user/urls", h.DeleteUserURLs)
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(auth.RequireAdmin(a.Config))
+			r.Get("/urls", h.AdminFindURLs)
+			r.Get("/urls/{id}", h.AdminGetURL)
+			r.Patch("/urls/{id}", h.AdminUpdateURL)
+			r.Delete("/urls/{id}", h.AdminDeleteURL)
+			r.Get("/urls/{id}/rules", h.AdminGetRules)
+			r.Put("/urls/{id}/rules", h.AdminSetRules)
+			r.Get("/urls/{id}/split", h.AdminGetSplit)
+			r.Put("/urls/{id}/split", h.AdminSetSplit)
+			r.Get("/events", h.AdminEvents)
+			r.Get("/export", h.AdminExport)
+			r.Post("/import", h.AdminImport)
+			r.Get("/domains", h.AdminDomains)
+			r.Get("/migration", h.AdminMigration)
+			r.Post("/config/reload", h.AdminReloadConfig)
+		})
 		r.Route("/{id}", func(r chi.Router) {
-			r.Get("/", handlers.Expand)
+			r.With(redirectLimit).Get("/", h.Expand)
+			r.With(redirectLimit).Get("/qr", h.LinkQRCode)
+			r.With(redirectLimit).Get("/*", h.Expand)
+			r.With(redirectLimit).Post("/", h.Expand)
+			r.With(redirectLimit).Post("/*", h.Expand)
 		})
 	})
 	return r
 }
 
-func Run() {
-	flag.StringVar(&config.Current.ServerAddress, "a", "", "Server address host:port")
-	flag.StringVar(&config.Current.BaseURL, "b", "", "Base for short URL")
-	flag.StringVar(&config.Current.FileStoragePath, "r", "", "Base for short URL")
-	flag.StringVar(&config.Current.DatabaseDSN, "d", "", "Database source string")
-	flag.Parse()
+func (a *App) Handler() http.Handler {
+	var handler http.Handler = a.Router()
+	if prefix := a.Config.Get().PathPrefix; prefix != "" {
+		handler = stripPrefix(prefix, handler)
+	}
+	return a.Proxies.Middleware(handler)
+}
 
-	if err := env.Parse(&config.Current); err != nil {
-		panic(err)
+// stripPrefix answers requests outside prefix with 404.
+func stripPrefix(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		rawRest, rawOK := strings.CutPrefix(r.URL.RawPath, prefix)
+		if !ok || rest != "" && !strings.HasPrefix(rest, "/") || r.URL.RawPath != "" && !rawOK {
+			problem.Respond(w, r, problem.New(problem.NotFound, "Not found."))
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = "/" + strings.TrimPrefix(rawRest, "/")
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+func (a *App) GRPCServer() *grpc.Server {
+	return grpcapi.NewServer(&grpcapi.Server{
+		Store:     a.Store,
+		Config:    a.Config,
+		Validator: a.Validator,
+		Domains:   a.Domains,
+		GeoIP:     a.GeoIP,
+		Limiters:  a.limiters,
+	}, a.Log.SugaredLogger)
+}
+
+func (a *App) Serve() error {
+	if migrating, ok := a.Store.(*storage.MigratingStore); ok {
+		go func() {
+			if err := migrating.Run(logger.WithContext(context.Background(), a.Log.SugaredLogger)); err != nil {
+				a.Log.Errorw("storage migration failed", "error", err)
+			}
+		}()
+	}
+
+	cfg := a.Config.Get()
+	listener, err := net.Listen("tcp", cfg.GRPCAddress)
+	if err != nil {
+		return fmt.Errorf("listen grpc: %w", err)
 	}
+	grpcServer := a.GRPCServer()
 
-	config.SetDefaults()
+	httpServer := &http.Server{Addr: cfg.ServerAddress, Handler: a.Handler()}
 
-	if err := logger.Initialize(); err != nil {
-		panic(err)
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		a.Log.Infow("starting grpc server", "address", cfg.GRPCAddress)
+		return grpcServer.Serve(listener)
+	})
+	g.Go(func() error {
+		a.Log.Infow("starting server", "address", cfg.ServerAddress)
+		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	g.Go(func() error {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		err := httpServer.Shutdown(shutdownCtx)
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
+		return err
+	})
+	err = g.Wait()
+	if flushErr := storage.FlushClicks(a.Store); flushErr != nil {
+		a.Log.Errorw("flush click log failed", "error", flushErr)
+	}
+	return err
+}
+
+func (a *App) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		result, err := a.Config.Reload()
+		if err != nil {
+			a.Log.Errorw("config reload failed", "error", err)
+			continue
+		}
+		a.Log.Infow("config reloaded", "applied", result.Applied, "restart_required", result.RestartRequired)
+	}
+}
+
+func createLimit(cfg *config.Config) ratelimit.Limit {
+	return ratelimit.PerPeriod("create", cfg.RateLimitCreate, time.Minute)
+}
+
+func redirectLimit(cfg *config.Config) ratelimit.Limit {
+	return ratelimit.PerPeriod("redirect", cfg.RateLimitRedirect, time.Minute)
+}
+
+func rateLimitBackend(cfg *config.Config, store storage.StoreHandler) (ratelimit.Backend, error) {
+	switch cfg.RateLimitBackend {
+	case "memory":
+		return ratelimit.NewMemoryBackend(), nil
+	case "database":
+		db, ok := storage.Database(store)
+		if !ok {
+			return nil, errors.New("database rate limit backend requires DATABASE_DSN")
+		}
+		return &ratelimit.DatabaseBackend{DB: db.DB}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend: %s", cfg.RateLimitBackend)
+	}
+}
+
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Source, *logger.Logger, error) {
+	var flags config.Config
+	fs.StringVar(&flags.ServerAddress, "a", "", "Server address host:port")
+	fs.StringVar(&flags.GRPCAddress, "g", "", "gRPC server address host:port")
+	fs.StringVar(&flags.BaseURL, "b", "", "Base for short URL")
+	fs.StringVar(&flags.FileStoragePath, "r", "", "Base for short URL")
+	fs.StringVar(&flags.DatabaseDSN, "d", "", "Database source string")
+	fs.StringVar(&flags.ConfigFile, "c", "", "Config file with KEY=VALUE lines")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
 	}
 
-	if config.Current.DatabaseDSN != "" {
-		handlers.StoreHandler = &storage.DatabaseStore{}
-	} else {
-		handlers.StoreHandler = &storage.MemoryStore{}
+	cfg, err := config.Load(flags)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if err := handlers.StoreHandler.Initialize(); err != nil {
-		panic(err)
+	log, err := logger.New(cfg.Get())
+	if err != nil {
+		return nil, nil, fmt.Errorf("initialize logger: %w", err)
+	}
+	return cfg, log, nil
+}
+
+func openStore(cfg *config.Source, log *logger.Logger) (storage.StoreHandler, error) {
+	var store storage.StoreHandler
+	switch {
+	case cfg.Get().MigrateStorage:
+		if cfg.Get().DatabaseDSN == "" {
+			return nil, errors.New("storage migration requires DATABASE_DSN")
+		}
+		store = storage.NewMigratingStore(cfg, &storage.MemoryStore{Config: cfg}, &storage.DatabaseStore{Config: cfg})
+	case cfg.Get().DatabaseDSN != "":
+		store = &storage.DatabaseStore{Config: cfg}
+	default:
+		store = &storage.MemoryStore{Config: cfg}
+	}
+
+	if err := store.Initialize(logger.WithContext(context.Background(), log.SugaredLogger)); err != nil {
+		return nil, fmt.Errorf("initialize storage: %w", err)
+	}
+	return store, nil
+}
+
+func Run(args []string) error {
+	cfg, log, err := loadConfig(flag.NewFlagSet("shortener", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Initialize(context.Background(), cfg.Get().TracingEndpoint)
+	if err != nil {
+		return fmt.Errorf("initialize tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	store, err := openStore(cfg, log)
+	if err != nil {
+		return err
 	}
 
-	err := http.ListenAndServe(config.Current.ServerAddress, router())
+	a, err := New(cfg, log, store)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	go a.reloadOnSignal()
+	return a.Serve()
 }
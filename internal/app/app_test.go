@@ -0,0 +1,382 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// The password form of protected links is HTML, validated like plain text.
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.RegisteredBodyDecoder("text/plain"))
+}
+
+func newTestApp(t *testing.T, configure ...func(cfg *config.Config)) *App {
+	t.Helper()
+	cfg := config.Default()
+	dir := t.TempDir()
+	cfg.FileStoragePath = dir + "/urls.json"
+	cfg.APIKeysPath = dir + "/api_keys.json"
+	cfg.EventLogPath = dir + "/events.json"
+	cfg.ClickLogPath = dir + "/clicks.json"
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	source := config.New(cfg)
+	a, err := New(source, logger.Nop(), &storage.MemoryStore{Config: source})
+	require.NoError(t, err)
+	return a
+}
+
+func TestSpecMatchesRoutes(t *testing.T) {
+	t.Parallel()
+	a := newTestApp(t)
+	r, spec := a.Router(), a.spec
+
+	var routes []string
+	err := chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		// OpenAPI has no wildcards, the rest of the path is documented as {path}.
+		route = strings.Replace(route, "/*", "/{path}", 1)
+		routes = append(routes, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	var documented []string
+	for path, item := range spec.Doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, documented, routes)
+}
+
+func TestResponsesMatchSpec(t *testing.T) {
+	t.Parallel()
+	a := newTestApp(t)
+	r := a.Router()
+	specRouter, err := legacy.NewRouter(a.spec.Doc)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		accept      string
+		body        string
+		status      int
+	}{
+		{"shorten", http.MethodPost, "/", "text/plain", "", "https://practicum.yandex.ru", http.StatusCreated},
+		{"shorten invalid URL", http.MethodPost, "/", "text/plain", "", "practicum", http.StatusBadRequest},
+		{"shorten invalid URL as JSON", http.MethodPost, "/", "text/plain", "application/json", "practicum", http.StatusBadRequest},
+		{"shorten API", http.MethodPost, "/api/shorten", "application/json", "", `{"url": "https://ya.ru"}`, http.StatusCreated},
+		{"shorten API conflict", http.MethodPost, "/api/shorten", "application/json", "", `{"url": "https://ya.ru"}`, http.StatusConflict},
+		{"shorten API invalid URL", http.MethodPost, "/api/shorten", "application/json", "", `{"url": "ya.ru"}`, http.StatusBadRequest},
+		{"shorten API missing URL", http.MethodPost, "/api/shorten", "application/json", "", `{"uri": "https://ya.ru"}`, http.StatusBadRequest},
+		{"shorten batch", http.MethodPost, "/api/shorten/batch", "application/json", "",
+			`[{"correlation_id": "1", "original_url": "https://go.dev/blog"}]`, http.StatusCreated},
+		{"shorten batch conflict", http.MethodPost, "/api/shorten/batch", "application/json", "",
+			`[{"correlation_id": "1", "original_url": "https://ya.ru"}]`, http.StatusConflict},
+		{"shorten batch not an array", http.MethodPost, "/api/shorten/batch", "application/json", "", `{}`, http.StatusBadRequest},
+		{"user urls without key", http.MethodGet, "/api/user/urls", "", "", "", http.StatusUnauthorized},
+		{"user urls invalid sort", http.MethodGet, "/api/user/urls?sort=title", "", "", "", http.StatusBadRequest},
+		{"user urls invalid limit", http.MethodGet, "/api/user/urls?limit=5000", "", "", "", http.StatusBadRequest},
+		{"admin API disabled", http.MethodGet, "/api/admin/urls/abc", "", "", "", http.StatusForbidden},
+		{"expand unknown ID", http.MethodGet, "/unknown1", "", "", "", http.StatusNotFound},
+		{"expand unknown ID as JSON", http.MethodGet, "/unknown1", "", "application/problem+json", "", http.StatusNotFound},
+		{"shorten API with QR code", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://go.dev", "qr": "svg"}`, http.StatusCreated},
+		{"shorten API with unknown QR format", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://go.dev", "qr": "gif"}`, http.StatusBadRequest},
+		{"shorten API with passthrough", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://go.dev/?a=1", "passthrough": "merge"}`, http.StatusCreated},
+		{"shorten API with unknown passthrough", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://go.dev", "passthrough": "append"}`, http.StatusBadRequest},
+		{"shorten API with custom prefix code", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://docs.example.com", "code": "docs", "prefix": true}`, http.StatusCreated},
+		{"shorten API with taken code", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://docs.example.com/other", "code": "docs"}`, http.StatusConflict},
+		{"shorten API with reserved code", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://docs.example.com", "code": "api"}`, http.StatusBadRequest},
+		{"expand deep path", http.MethodGet, "/docs/api", "", "", "", http.StatusTemporaryRedirect},
+		{"shorten API with split", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://ab.example.com", "code": "ab", "split": [{"destination": "https://ab.example.com/a", "weight": 1}]}`,
+			http.StatusCreated},
+		{"shorten API with invalid split", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://ab.example.com/b", "split": [{"destination": "https://ab.example.com/a", "weight": 0}]}`,
+			http.StatusBadRequest},
+		{"expand split link", http.MethodGet, "/ab", "", "", "", http.StatusTemporaryRedirect},
+		{"shorten API with password", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://secret.example.com", "code": "secret", "password": "hunter2", "max_clicks": 1}`,
+			http.StatusCreated},
+		{"password form", http.MethodGet, "/secret", "", "", "", http.StatusOK},
+		{"wrong password", http.MethodPost, "/secret", "application/x-www-form-urlencoded", "", "password=hunter3",
+			http.StatusForbidden},
+		{"right password", http.MethodPost, "/secret", "application/x-www-form-urlencoded", "", "password=hunter2",
+			http.StatusSeeOther},
+		{"one-time link used up", http.MethodPost, "/secret", "application/x-www-form-urlencoded", "", "password=hunter2",
+			http.StatusGone},
+		{"shorten API with metadata", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://meta.example.com", "title": "Meta", "description": "About meta.", "tags": ["docs", "Team:web"]}`,
+			http.StatusCreated},
+		{"shorten API with invalid tag", http.MethodPost, "/api/shorten", "application/json", "",
+			`{"url": "https://meta.example.com/2", "tags": ["two words"]}`, http.StatusBadRequest},
+		{"expand deep path of unknown ID", http.MethodGet, "/unknown1/api", "", "", "", http.StatusNotFound},
+		{"qr code unknown ID", http.MethodGet, "/api/qr/unknown1", "", "", "", http.StatusNotFound},
+		{"qr code invalid size", http.MethodGet, "/api/qr/unknown1?size=1", "", "", "", http.StatusBadRequest},
+		{"liveness", http.MethodGet, "/healthz", "", "", "", http.StatusOK},
+		{"readiness", http.MethodGet, "/readyz", "", "", "", http.StatusOK},
+		{"spec", http.MethodGet, "/api/openapi.json", "", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, request)
+			resp := rec.Result()
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode, string(body))
+
+			route, pathParams, err := specRouter.FindRoute(request)
+			require.NoError(t, err)
+			err = openapi3filter.ValidateResponse(request.Context(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{
+					Request:    request,
+					PathParams: pathParams,
+					Route:      route,
+				},
+				Status: resp.StatusCode,
+				Header: resp.Header,
+				Body:   io.NopCloser(bytes.NewReader(body)),
+			})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestIndependentInstances(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		baseURL string
+		blocked string
+	}{
+		{"first", "http://first.example", "ya.ru"},
+		{"second", "http://second.example", "practicum.yandex.ru"},
+	}
+	servers := make(map[string]*httptest.Server)
+	for _, tt := range tests {
+		a := newTestApp(t, func(cfg *config.Config) {
+			cfg.BaseURL = tt.baseURL
+			cfg.Blocklist = []string{tt.blocked}
+		})
+		servers[tt.name] = httptest.NewServer(a.Router())
+		t.Cleanup(servers[tt.name].Close)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := servers[tt.name]
+
+			resp, err := client.Post(server.URL+"/", "text/plain", strings.NewReader("https://"+tt.blocked))
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			resp, err = client.Post(server.URL+"/", "text/plain", strings.NewReader("https://go.dev"))
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			assert.True(t, strings.HasPrefix(string(body), tt.baseURL+"/"), string(body))
+			id := string(body)[len(tt.baseURL)+1:]
+
+			resp, err = client.Get(server.URL + "/" + id)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			for name, other := range servers {
+				if name == tt.name {
+					continue
+				}
+				resp, err = client.Get(other.URL + "/" + id)
+				require.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestPathPrefixBehindProxy(t *testing.T) {
+	t.Parallel()
+	a := newTestApp(t, func(cfg *config.Config) {
+		cfg.BaseURL = "https://corp.example"
+		cfg.PathPrefix = "/s"
+		cfg.Domains = []string{"https://go.example.com"}
+		cfg.TrustedProxies = []string{"192.0.2.1"}
+	})
+	handler := a.Handler()
+
+	send := func(method, target, body, remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-Forwarded-For", "198.51.100.7")
+		request.Header.Set("X-Forwarded-Host", "go.example.com")
+		request.Header.Set("X-Forwarded-Proto", "https")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := send(http.MethodPost, "/s", "https://go.dev", "192.0.2.1:1234")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Regexp(t, "^https://go.example.com/s/.{8}$", rec.Body.String())
+	id := rec.Body.String()[strings.LastIndex(rec.Body.String(), "/")+1:]
+
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		status     int
+	}{
+		{"through the proxy", "/s/" + id, "192.0.2.1:1234", http.StatusTemporaryRedirect},
+		{"untrusted forwarded host", "/s/" + id, "203.0.113.9:1234", http.StatusNotFound},
+		{"outside the prefix", "/" + id, "192.0.2.1:1234", http.StatusNotFound},
+		{"similar prefix", "/sx/" + id, "192.0.2.1:1234", http.StatusNotFound},
+		{"spec under the prefix", "/s/api/openapi.json", "192.0.2.1:1234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, send(http.MethodGet, tt.path, "", tt.remoteAddr).Code)
+		})
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	t.Parallel()
+	var got *http.Request
+	handler := stripPrefix("/s", http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { got = r }))
+
+	request := httptest.NewRequest(http.MethodGet, "/s/docs/a%2Fb", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	require.NotNil(t, got)
+	assert.Equal(t, "/docs/a/b", got.URL.Path)
+	assert.Equal(t, "/docs/a%2Fb", got.URL.RawPath)
+	assert.Equal(t, "/s/docs/a/b", request.URL.Path, "the original request is left alone")
+}
+
+func TestQRCodeRouteBesidePrefixLinks(t *testing.T) {
+	t.Parallel()
+	a := newTestApp(t)
+	handler := a.Handler()
+	_, err := a.Store.Save(context.Background(),
+		storage.URLStore{ShortURL: "docs", OriginalURL: "https://go.dev", Prefix: true})
+	require.NoError(t, err)
+	_, err = a.Store.Save(context.Background(), storage.URLStore{ShortURL: "plain", OriginalURL: "https://go.dev/doc"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		location string
+	}{
+		{"qr path below a prefix link", "/docs/qr", http.StatusTemporaryRedirect, "https://go.dev/qr"},
+		{"qr code of the prefix link", "/api/qr/docs", http.StatusOK, ""},
+		{"qr code of a plain link", "/plain/qr", http.StatusOK, ""},
+		{"qr code of a plain link under api", "/api/qr/plain", http.StatusOK, ""},
+		{"qr code of an unknown link", "/unknown1/qr", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.location, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestBatchChargesCreateLimitPerURL(t *testing.T) {
+	t.Parallel()
+	a := newTestApp(t, func(cfg *config.Config) { cfg.RateLimitCreate = 3 })
+	handler := a.Handler()
+	batch := `[{"correlation_id":"1","original_url":"https://go.dev/a"},{"correlation_id":"2","original_url":"https://go.dev/b"}]`
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"batch of two", "/api/shorten/batch", batch, http.StatusCreated},
+		{"batch over the remaining tokens", "/api/shorten/batch", batch, http.StatusTooManyRequests},
+		{"single URL with the last token", "/", "https://go.dev/c", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		assert.Equal(t, tt.status, rec.Code, tt.name)
+	}
+}
+
+func TestServeStopsBothServers(t *testing.T) {
+	t.Parallel()
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	a := newTestApp(t, func(cfg *config.Config) {
+		cfg.ServerAddress = busy.Addr().String()
+		cfg.GRPCAddress = "127.0.0.1:0"
+	})
+	done := make(chan error, 1)
+	go func() { done <- a.Serve() }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve kept running after the HTTP server failed")
+	}
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alexch365/go-url-shortener/internal/dump"
+)
+
+func RunExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", dump.FormatJSONL, "Output format: jsonl or csv")
+	output := fs.String("o", "-", "Output file, - for stdout")
+	cfg, log, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := dump.Export(context.Background(), store, w, *format)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d links\n", count)
+	return nil
+}
+
+func RunImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", dump.FormatJSONL, "Input format: jsonl or csv")
+	input := fs.String("i", "-", "Input file, - for stdin")
+	cfg, log, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	result, err := dump.Import(context.Background(), store, r, *format)
+	fmt.Fprintf(os.Stderr, "read %d, imported %d, skipped %d existing\n", result.Read, result.Imported, result.Skipped)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"compress/gzip"
+	"github.com/alexch365/go-url-shortener/internal/problem"
 	"io"
 	"net/http"
 	"slices"
@@ -61,7 +62,7 @@ func gzipMiddleware(next http.Handler) http.Handler {
 		if strings.Contains(contentEncoding, "gzip") {
 			gzr, err := gzip.NewReader(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.Respond(w, r, problem.Wrap(problem.Validation, err, "Invalid gzip request body."))
 				return
 			}
 			r.Body = gzipReader{r.Body, gzr}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/auth"
+)
+
+const keysUsage = `usage: shortener keys <command> [flags]
+
+commands:
+  create -name NAME [-scopes shorten,batch] [-quota N]
+  list
+  revoke ID`
+
+func RunKeys(args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		return createKey(args[1:])
+	case "list":
+		return listKeys(args[1:])
+	case "revoke":
+		return revokeKey(args[1:])
+	default:
+		return fmt.Errorf("unknown keys command: %s\n%s", args[0], keysUsage)
+	}
+}
+
+func createKey(args []string) error {
+	fs := flag.NewFlagSet("keys create", flag.ExitOnError)
+	name := fs.String("name", "", "Key name")
+	scopes := fs.String("scopes", auth.ScopeShorten, "Comma-separated scopes: "+strings.Join(auth.Scopes, ","))
+	quota := fs.Int("quota", 0, "Maximum number of links the key may create, 0 for unlimited")
+	cfg, log, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("key name is required")
+	}
+
+	token, key, err := auth.NewAPIKey(*name, strings.Split(*scopes, ","), *quota)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg, log)
+	if err != nil {
+		return err
+	}
+	if err := store.CreateAPIKey(context.Background(), key); err != nil {
+		return err
+	}
+
+	fmt.Printf("id:    %s\ntoken: %s\n", key.ID, token)
+	fmt.Println("The token is shown only once, store it securely.")
+	return nil
+}
+
+func listKeys(args []string) error {
+	cfg, log, err := loadConfig(flag.NewFlagSet("keys list", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg, log)
+	if err != nil {
+		return err
+	}
+	keys, err := store.ListAPIKeys(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tUSED/QUOTA\tCREATED\tREVOKED")
+	for _, key := range keys {
+		revoked := "-"
+		if key.Revoked() {
+			revoked = key.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\n", key.ID, key.Name, strings.Join(key.Scopes, ","),
+			key.Used, key.Quota, key.CreatedAt.Format(time.RFC3339), revoked)
+	}
+	return w.Flush()
+}
+
+func revokeKey(args []string) error {
+	fs := flag.NewFlagSet("keys revoke", flag.ExitOnError)
+	cfg, log, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: shortener keys revoke ID")
+	}
+
+	store, err := openStore(cfg, log)
+	if err != nil {
+		return err
+	}
+	if err := store.RevokeAPIKey(context.Background(), fs.Arg(0)); err != nil {
+		return err
+	}
+
+	fmt.Printf("revoked %s\n", fs.Arg(0))
+	return nil
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/auth"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+)
+
+const (
+	ActorAdmin     = "admin"
+	ActorAnonymous = "anonymous"
+)
+
+// Record never fails the request that caused the events.
+func Record(ctx context.Context, store storage.EventStore, events ...storage.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	actor := Actor(ctx)
+	now := time.Now().UTC()
+	for i := range events {
+		events[i].Actor = actor
+		events[i].CreatedAt = now
+	}
+
+	if err := store.RecordEvents(ctx, events); err != nil {
+		logger.FromContext(ctx).Errorw("record events failed", "count", len(events), "error", err)
+	}
+}
+
+func Actor(ctx context.Context) string {
+	if auth.IsAdmin(ctx) {
+		return ActorAdmin
+	}
+	if key, ok := auth.FromContext(ctx); ok {
+		return key.ID
+	}
+	return ActorAnonymous
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/util"
+)
+
+const (
+	ScopeShorten = "shorten"
+	ScopeBatch   = "batch"
+	ScopeStats   = "stats"
+	ScopeDelete  = "delete"
+
+	AdminTokenHeader = "X-Admin-Token"
+
+	tokenPrefix = "sk_"
+)
+
+var (
+	Scopes = []string{ScopeShorten, ScopeBatch, ScopeStats, ScopeDelete}
+
+	ErrUnauthorized = errors.New("invalid API key")
+)
+
+type (
+	contextKey      struct{}
+	adminContextKey struct{}
+)
+
+func NewAPIKey(name string, scopes []string, quota int) (string, storage.APIKey, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", storage.APIKey{}, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+	if quota < 0 {
+		return "", storage.APIKey{}, errors.New("quota must not be negative")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", storage.APIKey{}, err
+	}
+	token := tokenPrefix + hex.EncodeToString(secret)
+
+	key := storage.APIKey{
+		ID:        util.RandomString(12),
+		Name:      name,
+		Hash:      Hash(token),
+		Scopes:    scopes,
+		Quota:     quota,
+		CreatedAt: time.Now().UTC(),
+	}
+	return token, key, nil
+}
+
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func FromContext(ctx context.Context) (storage.APIKey, bool) {
+	key, ok := ctx.Value(contextKey{}).(storage.APIKey)
+	return key, ok
+}
+
+func Authenticate(ctx context.Context, store storage.APIKeyStore, header string) (storage.APIKey, error) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(token, tokenPrefix) {
+		return storage.APIKey{}, ErrUnauthorized
+	}
+
+	key, err := store.FindAPIKey(ctx, Hash(token))
+	if errors.Is(err, storage.ErrAPIKeyNotFound) || err == nil && key.Revoked() {
+		return storage.APIKey{}, ErrUnauthorized
+	}
+	if err != nil {
+		logger.FromContext(ctx).Errorw("api key lookup failed", "error", err)
+		return storage.APIKey{}, ErrUnauthorized
+	}
+	return key, nil
+}
+
+func WithAPIKey(ctx context.Context, key storage.APIKey) context.Context {
+	ctx = context.WithValue(ctx, contextKey{}, key)
+	return logger.WithContext(ctx, logger.FromContext(ctx).With("api_key_id", key.ID))
+}
+
+func IsAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminContextKey{}).(bool)
+	return admin
+}
+
+func validAdminToken(token, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// Middleware does not require operators to hold an API key.
+func Middleware(store storage.APIKeyStore, cfg *config.Source) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := r.Header.Get(AdminTokenHeader); token != "" {
+				if !validAdminToken(token, cfg.Get().AdminToken) {
+					problem.Respond(w, r, problem.New(problem.Unauthorized, "Invalid admin token."))
+					return
+				}
+				ctx := context.WithValue(r.Context(), adminContextKey{}, true)
+				r = r.WithContext(logger.WithContext(ctx, logger.FromContext(ctx).With("admin", true)))
+			}
+
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				if cfg.Get().RequireAPIKey && !IsAdmin(r.Context()) {
+					unauthorized(w, r, "API key required.")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := Authenticate(r.Context(), store, header)
+			if err != nil {
+				unauthorized(w, r, "Invalid API key.")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithAPIKey(r.Context(), key)))
+		})
+	}
+}
+
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := FromContext(r.Context()); ok && !key.HasScope(scope) {
+				problem.Respond(w, r, problem.New(problem.Forbidden, "API key lacks the %q scope.", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func RequireAdmin(cfg *config.Source) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsAdmin(r.Context()) {
+				if cfg.Get().AdminToken == "" {
+					problem.Respond(w, r, problem.New(problem.Forbidden, "Admin API is disabled."))
+					return
+				}
+				problem.Respond(w, r, problem.New(problem.Unauthorized, "Admin token required."))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="shortener"`)
+	problem.Respond(w, r, problem.New(problem.Unauthorized, "%s", message))
+}
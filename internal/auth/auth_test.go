@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	cfg := config.Default()
+	cfg.APIKeysPath = t.TempDir() + "/api_keys.json"
+	source := config.New(cfg)
+	store := &storage.MemoryStore{Config: source}
+
+	token, key, err := NewAPIKey("ci", []string{ScopeShorten}, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAPIKey(context.TODO(), key))
+
+	revokedToken, revokedKey, err := NewAPIKey("old", []string{ScopeShorten, ScopeBatch}, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAPIKey(context.TODO(), revokedKey))
+	require.NoError(t, store.RevokeAPIKey(context.TODO(), revokedKey.ID))
+
+	handler := Middleware(store, source)(RequireScope(ScopeShorten)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}),
+	))
+	batchHandler := Middleware(store, source)(RequireScope(ScopeBatch)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}),
+	))
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		header  string
+		status  int
+	}{
+		{"without key", handler, "", http.StatusCreated},
+		{"with valid key", handler, "Bearer " + token, http.StatusCreated},
+		{"with lowercase scheme", handler, "bearer " + token, http.StatusCreated},
+		{"with missing scope", batchHandler, "Bearer " + token, http.StatusForbidden},
+		{"with revoked key", handler, "Bearer " + revokedToken, http.StatusUnauthorized},
+		{"with unknown key", handler, "Bearer sk_unknown", http.StatusUnauthorized},
+		{"with basic auth", handler, "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, request)
+
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		configured string
+		token      string
+		status     int
+	}{
+		{"with valid token", "secret", "secret", http.StatusOK},
+		{"with invalid token", "secret", "guess", http.StatusUnauthorized},
+		{"without token", "secret", "", http.StatusUnauthorized},
+		{"when disabled", "", "", http.StatusForbidden},
+		{"when disabled with token", "", "secret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.AdminToken = tt.configured
+			source := config.New(cfg)
+			handler := Middleware(&storage.MemoryStore{Config: source}, source)(RequireAdmin(source)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+			))
+			request := httptest.NewRequest(http.MethodGet, "/api/admin/urls", nil)
+			if tt.token != "" {
+				request.Header.Set(AdminTokenHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
@@ -1,29 +1,143 @@
 package config
 
-type appConfig struct {
+import "strings"
+
+// Config fields tagged reload:"true" are picked up by Source.Reload.
+type Config struct {
+	ConfigFile      string `env:"CONFIG_FILE"`
 	ServerAddress   string `env:"SERVER_ADDRESS"`
+	GRPCAddress     string `env:"GRPC_ADDRESS"`
 	BaseURL         string `env:"BASE_URL"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
+	APIKeysPath     string `env:"API_KEYS_PATH"`
+	EventLogPath    string `env:"EVENT_LOG_PATH"`
+	ClickLogPath    string `env:"CLICK_LOG_PATH"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
+	MigrateStorage  bool   `env:"MIGRATE_STORAGE"`
+	TracingEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	RequireAPIKey   bool   `env:"REQUIRE_API_KEY" reload:"true"`
+	AdminToken      string `env:"ADMIN_TOKEN" reload:"true"`
+
+	Domains        []string `env:"DOMAINS" envSeparator:"," reload:"true"`
+	PathPrefix     string   `env:"PATH_PREFIX"`
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:"," reload:"true"`
+
+	LogLevel            string `env:"LOG_LEVEL" reload:"true"`
+	LogFormat           string `env:"LOG_FORMAT"`
+	LogRedirectSampling int    `env:"LOG_REDIRECT_SAMPLING" reload:"true"`
+
+	// Rate limits are per client and minute, zero turns them off.
+	RateLimitBackend  string   `env:"RATE_LIMIT_BACKEND"`
+	RateLimitCreate   int      `env:"RATE_LIMIT_CREATE" reload:"true"`
+	RateLimitRedirect int      `env:"RATE_LIMIT_REDIRECT" reload:"true"`
+	BatchMaxSize      int      `env:"BATCH_MAX_SIZE" reload:"true"`
+	Blocklist         []string `env:"BLOCKLIST" envSeparator:"," reload:"true"`
+
+	GeoIPDatabase string `env:"GEOIP_DATABASE" reload:"true"`
+
+	FetchPreviews bool `env:"FETCH_PREVIEWS" reload:"true"`
+
+	MigrationReadFrom   string `env:"MIGRATION_READ_FROM" reload:"true"`
+	MigrationStatusPath string `env:"MIGRATION_STATUS_PATH"`
 }
 
-var defaults = appConfig{
+var defaults = Config{
+	ConfigFile:      "",
 	ServerAddress:   "localhost:8080",
+	GRPCAddress:     "localhost:3200",
 	BaseURL:         "http://localhost:8080",
 	FileStoragePath: "shorten_urls.json",
+	APIKeysPath:     "api_keys.json",
+	EventLogPath:    "url_events.json",
+	ClickLogPath:    "url_clicks.json",
 	DatabaseDSN:     "",
+	MigrateStorage:  false,
+	TracingEndpoint: "",
+	RequireAPIKey:   false,
+	AdminToken:      "",
+
+	Domains:        nil,
+	PathPrefix:     "",
+	TrustedProxies: nil,
+
+	LogLevel:            "info",
+	LogFormat:           "json",
+	LogRedirectSampling: 100,
+
+	RateLimitBackend:  "memory",
+	RateLimitCreate:   60,
+	RateLimitRedirect: 600,
+	BatchMaxSize:      1000,
+	Blocklist:         nil,
+
+	GeoIPDatabase: "",
+
+	FetchPreviews: false,
+
+	MigrationReadFrom:   "old",
+	MigrationStatusPath: "storage_migration.json",
 }
 
-var Current = appConfig{}
+func Default() Config {
+	cfg := withLimits(Config{})
+	cfg.SetDefaults()
+	return cfg
+}
 
-func SetDefaults() {
-	if Current.ServerAddress == "" {
-		Current.ServerAddress = defaults.ServerAddress
+// withLimits goes under the config file and the environment, zero turns a
+// limit off.
+func withLimits(cfg Config) Config {
+	if cfg.RateLimitCreate == 0 {
+		cfg.RateLimitCreate = defaults.RateLimitCreate
+	}
+	if cfg.RateLimitRedirect == 0 {
+		cfg.RateLimitRedirect = defaults.RateLimitRedirect
+	}
+	return cfg
+}
+
+func (c *Config) SetDefaults() {
+	if c.ServerAddress == "" {
+		c.ServerAddress = defaults.ServerAddress
+	}
+	if c.GRPCAddress == "" {
+		c.GRPCAddress = defaults.GRPCAddress
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = defaults.BaseURL
+	}
+	if c.FileStoragePath == "" {
+		c.FileStoragePath = defaults.FileStoragePath
+	}
+	if c.APIKeysPath == "" {
+		c.APIKeysPath = defaults.APIKeysPath
+	}
+	if c.EventLogPath == "" {
+		c.EventLogPath = defaults.EventLogPath
+	}
+	if c.ClickLogPath == "" {
+		c.ClickLogPath = defaults.ClickLogPath
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = defaults.LogLevel
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = defaults.LogFormat
+	}
+	if c.LogRedirectSampling == 0 {
+		c.LogRedirectSampling = defaults.LogRedirectSampling
+	}
+	if c.RateLimitBackend == "" {
+		c.RateLimitBackend = defaults.RateLimitBackend
+	}
+	if c.BatchMaxSize == 0 {
+		c.BatchMaxSize = defaults.BatchMaxSize
 	}
-	if Current.BaseURL == "" {
-		Current.BaseURL = defaults.BaseURL
+	if c.MigrationReadFrom == "" {
+		c.MigrationReadFrom = defaults.MigrationReadFrom
 	}
-	if Current.FileStoragePath == "" {
-		Current.FileStoragePath = defaults.FileStoragePath
+	if c.MigrationStatusPath == "" {
+		c.MigrationStatusPath = defaults.MigrationStatusPath
 	}
+	c.PathPrefix = strings.TrimRight(c.PathPrefix, "/")
 }
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/proxy"
+	"github.com/caarlos0/env"
+	"go.uber.org/zap/zapcore"
+)
+
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+type Source struct {
+	active atomic.Pointer[Config]
+	// flags is the lowest layer, under the config file and the environment.
+	flags Config
+
+	mu          sync.Mutex
+	subscribers []func()
+}
+
+func New(cfg Config) *Source {
+	source := &Source{flags: cfg}
+	source.active.Store(&cfg)
+	return source
+}
+
+func Load(flags Config) (*Source, error) {
+	source := &Source{flags: flags}
+	cfg, err := source.build()
+	if err != nil {
+		return nil, err
+	}
+	source.active.Store(cfg)
+	return source, nil
+}
+
+// Get returns a snapshot that must not be modified.
+func (s *Source) Get() *Config {
+	return s.active.Load()
+}
+
+func (s *Source) Subscribe(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Reload keeps the old values of fields that cannot be reloaded and reports
+// them in RestartRequired. Subscribers run after the lock is released.
+func (s *Source) Reload() (ReloadResult, error) {
+	result, subscribers, err := s.swap()
+	if err != nil {
+		return result, err
+	}
+	for _, fn := range subscribers {
+		fn()
+	}
+	return result, nil
+}
+
+func (s *Source) swap() (ReloadResult, []func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, err := s.build()
+	if err != nil {
+		return ReloadResult{}, nil, err
+	}
+
+	prev := s.Get()
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	prevValue, nextValue := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < nextValue.NumField(); i++ {
+		if reflect.DeepEqual(prevValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+		field := nextValue.Type().Field(i)
+		if field.Tag.Get("reload") == "true" {
+			result.Applied = append(result.Applied, field.Tag.Get("env"))
+		} else {
+			result.RestartRequired = append(result.RestartRequired, field.Tag.Get("env"))
+			nextValue.Field(i).Set(prevValue.Field(i))
+		}
+	}
+
+	s.active.Store(next)
+	return result, slices.Clone(s.subscribers), nil
+}
+
+func (s *Source) build() (*Config, error) {
+	cfg := withLimits(s.flags)
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		path = s.flags.ConfigFile
+	}
+	if path != "" {
+		if err := parseFile(&cfg, path); err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+	}
+	if err := env.Parse(&cfg); err != nil {
+		return nil, fmt.Errorf("parse environment: %w", err)
+	}
+	cfg.SetDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// parseFile reads KEY=VALUE lines named like the environment.
+func parseFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fields := make(map[string]reflect.Value)
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		fields[value.Type().Field(i).Tag.Get("env")] = value.Field(i)
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, raw, found := strings.Cut(text, "=")
+		key, raw = strings.TrimSpace(key), strings.TrimSpace(raw)
+		field, ok := fields[key]
+		if !found || !ok {
+			return fmt.Errorf("line %d: unknown setting %q", line, key)
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("line %d: %s: %w", line, key, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case reflect.Int:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(value))
+	case reflect.Slice:
+		var values []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	if !slices.Contains([]string{"json", "console"}, c.LogFormat) {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: unknown format %q", c.LogFormat))
+	}
+	if !slices.Contains([]string{"memory", "database"}, c.RateLimitBackend) {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_BACKEND: unknown backend %q", c.RateLimitBackend))
+	}
+	if !slices.Contains([]string{"old", "new"}, c.MigrationReadFrom) {
+		errs = append(errs, fmt.Errorf("MIGRATION_READ_FROM: must be old or new, got %q", c.MigrationReadFrom))
+	}
+	if c.LogRedirectSampling < 0 || c.RateLimitCreate < 0 || c.RateLimitRedirect < 0 {
+		errs = append(errs, errors.New("LOG_REDIRECT_SAMPLING and RATE_LIMIT_* must not be negative"))
+	}
+	if c.BatchMaxSize < 1 {
+		errs = append(errs, errors.New("BATCH_MAX_SIZE: must be positive"))
+	}
+	if c.PathPrefix != "" && (!strings.HasPrefix(c.PathPrefix, "/") || strings.ContainsAny(c.PathPrefix, "?#% ")) {
+		errs = append(errs, fmt.Errorf("PATH_PREFIX: %q must be a path starting with /", c.PathPrefix))
+	}
+	if _, err := proxy.ParseNetworks(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+	for _, raw := range c.Domains {
+		if _, err := domains.Parse(raw); strings.TrimSpace(raw) != "" && err != nil {
+			errs = append(errs, fmt.Errorf("DOMAINS: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.conf")
+	t.Setenv("CONFIG_FILE", path)
+
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+
+	write("# initial\nLOG_LEVEL=info\nSERVER_ADDRESS=localhost:8080\n")
+	source, err := Load(Config{BaseURL: "http://short.example"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://short.example", source.Get().BaseURL)
+	assert.Equal(t, defaults.BatchMaxSize, source.Get().BatchMaxSize)
+
+	notified := 0
+	source.Subscribe(func() { notified++ })
+
+	tests := []struct {
+		name            string
+		content         string
+		wantErr         bool
+		applied         []string
+		restartRequired []string
+	}{
+		{
+			name:            "reloadable and fixed settings",
+			content:         "LOG_LEVEL=debug\nSERVER_ADDRESS=localhost:9090\nBLOCKLIST=evil.com, spam.example\n",
+			applied:         []string{"LOG_LEVEL", "BLOCKLIST"},
+			restartRequired: []string{"SERVER_ADDRESS"},
+		},
+		{
+			name:    "invalid value keeps running config",
+			content: "LOG_LEVEL=loud\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid trusted proxy",
+			content: "TRUSTED_PROXIES=10.0.0.0/8,proxy.internal\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown setting",
+			content: "NO_SUCH_SETTING=1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.content)
+			before := source.Get()
+			result, err := source.Reload()
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Same(t, before, source.Get())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.applied, result.Applied)
+			assert.Equal(t, tt.restartRequired, result.RestartRequired)
+		})
+	}
+
+	assert.Equal(t, 1, notified)
+	assert.Equal(t, "debug", source.Get().LogLevel)
+	assert.Equal(t, []string{"evil.com", "spam.example"}, source.Get().Blocklist)
+	assert.Equal(t, "localhost:8080", source.Get().ServerAddress)
+	assert.Equal(t, "http://short.example", source.Get().BaseURL)
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	t.Setenv("RATE_LIMIT_REDIRECT", "0")
+	source, err := Load(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, defaults.RateLimitCreate, source.Get().RateLimitCreate)
+	assert.Zero(t, source.Get().RateLimitRedirect)
+
+	t.Setenv("RATE_LIMIT_CREATE", "-1")
+	_, err = Load(Config{})
+	assert.Error(t, err)
+}
+
+func TestReloadSubscriberReentry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.conf")
+	t.Setenv("CONFIG_FILE", path)
+	require.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL=info\n"), 0600))
+	source, err := Load(Config{})
+	require.NoError(t, err)
+
+	nested := 0
+	source.Subscribe(func() {
+		source.Subscribe(func() { nested++ })
+		assert.Equal(t, "debug", source.Get().LogLevel)
+	})
+	require.NoError(t, os.WriteFile(path, []byte("LOG_LEVEL=debug\n"), 0600))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := source.Reload()
+		assert.NoError(t, err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload deadlocked in a subscriber")
+	}
+
+	_, err = source.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, 1, nested)
+}
@@ -0,0 +1,135 @@
+package domains
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Domain is a short domain, the default one from BASE_URL has an empty name.
+type Domain struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+}
+
+func (d Domain) ShortURL(code string) string {
+	return d.BaseURL + "/" + code
+}
+
+type state struct {
+	prefix       string
+	fallback     Domain
+	fallbackHost string
+	byName       map[string]Domain
+	ordered      []Domain
+}
+
+type Registry struct {
+	state atomic.Pointer[state]
+}
+
+func New(baseURL, prefix string, domains []string) (*Registry, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("base url: %w", err)
+	}
+	// A BASE_URL that already ends with the prefix is taken as is.
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if !strings.HasSuffix(baseURL, prefix) {
+		baseURL += prefix
+	}
+
+	registry := &Registry{}
+	registry.state.Store(&state{
+		prefix:       prefix,
+		fallback:     Domain{BaseURL: baseURL},
+		fallbackHost: strings.ToLower(parsed.Host),
+	})
+	if err = registry.Set(domains); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func Parse(raw string) (Domain, error) {
+	raw = strings.TrimSuffix(strings.TrimSpace(raw), "/")
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Domain{}, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return Domain{}, fmt.Errorf("%q is not an absolute http(s) URL", raw)
+	}
+	if parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return Domain{}, fmt.Errorf("%q must not have a path, query or fragment", raw)
+	}
+	return Domain{Name: strings.ToLower(parsed.Host), BaseURL: raw}, nil
+}
+
+func (r *Registry) Set(domains []string) error {
+	current := r.state.Load()
+	next := &state{
+		prefix:       current.prefix,
+		fallback:     current.fallback,
+		fallbackHost: current.fallbackHost,
+		byName:       make(map[string]Domain, len(domains)),
+	}
+	for _, raw := range domains {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		domain, err := Parse(raw)
+		if err != nil {
+			return err
+		}
+		if _, ok := next.byName[domain.Name]; ok || domain.Name == next.fallbackHost {
+			continue
+		}
+		domain.BaseURL += next.prefix
+		next.byName[domain.Name] = domain
+		next.ordered = append(next.ordered, domain)
+	}
+	r.state.Store(next)
+	return nil
+}
+
+func (r *Registry) Default() Domain {
+	return r.state.Load().fallback
+}
+
+func (r *Registry) ForHost(host string) Domain {
+	current := r.state.Load()
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if domain, ok := current.byName[host]; ok {
+		return domain
+	}
+	if hostname, _, found := strings.Cut(host, ":"); found {
+		if domain, ok := current.byName[hostname]; ok {
+			return domain
+		}
+	}
+	return current.fallback
+}
+
+func (r *Registry) Lookup(name string) (Domain, bool) {
+	current := r.state.Load()
+	if name == "" {
+		return current.fallback, true
+	}
+	domain, ok := current.byName[strings.ToLower(name)]
+	return domain, ok
+}
+
+func (r *Registry) List() []Domain {
+	current := r.state.Load()
+	return append([]Domain{current.fallback}, current.ordered...)
+}
+
+// Get assumes https for domains no longer configured.
+func (r *Registry) Get(name string) Domain {
+	if domain, ok := r.Lookup(name); ok {
+		return domain
+	}
+	return Domain{Name: name, BaseURL: "https://" + name + r.state.Load().prefix}
+}
@@ -0,0 +1,55 @@
+package domains
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry, err := New("http://localhost:8080", "", []string{"https://go.example.com", "https://Short.Example.org/"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		host    string
+		want    string
+		wantURL string
+	}{
+		{"default host", "localhost:8080", "", "http://localhost:8080"},
+		{"unknown host", "other.example.com", "", "http://localhost:8080"},
+		{"registered host", "go.example.com", "go.example.com", "https://go.example.com"},
+		{"registered host with port", "go.example.com:443", "go.example.com", "https://go.example.com"},
+		{"case insensitive", "SHORT.example.org", "short.example.org", "https://Short.Example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := registry.ForHost(tt.host)
+			assert.Equal(t, tt.want, domain.Name)
+			assert.Equal(t, tt.wantURL+"/abc", domain.ShortURL("abc"))
+		})
+	}
+
+	_, ok := registry.Lookup("go.example.com")
+	assert.True(t, ok)
+	require.NoError(t, registry.Set(nil))
+	_, ok = registry.Lookup("go.example.com")
+	assert.False(t, ok)
+	assert.Len(t, registry.List(), 1)
+
+	assert.Error(t, registry.Set([]string{"go.example.com"}))
+	assert.Error(t, registry.Set([]string{"https://example.com/path"}))
+}
+
+func TestRegistryPrefix(t *testing.T) {
+	registry, err := New("https://corp.example", "/s", []string{"https://go.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://corp.example/s/abc", registry.Default().ShortURL("abc"))
+	assert.Equal(t, "https://go.example.com/s/abc", registry.ForHost("go.example.com").ShortURL("abc"))
+	assert.Equal(t, "https://old.example.com/s/abc", registry.Get("old.example.com").ShortURL("abc"))
+
+	registry, err = New("https://corp.example/s/", "/s", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://corp.example/s/abc", registry.Default().ShortURL("abc"))
+}
@@ -0,0 +1,357 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/redirect"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/validate"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+
+	importBatchSize = 500
+	maxJSONLine     = 1 << 20
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown format, expected jsonl or csv")
+
+	// Older dumps lack the trailing columns. Rules, splits and tags are JSON.
+	csvHeader = []string{"short_url", "original_url", "user_id", "is_deleted", "is_disabled", "created_at", "domain",
+		"passthrough", "is_prefix", "rules", "split", "password_hash", "max_clicks", "clicks", "title", "description",
+		"tags"}
+
+	minCSVColumns = 6
+)
+
+type (
+	Result struct {
+		Read     int `json:"read"`
+		Imported int `json:"imported"`
+		Skipped  int `json:"skipped"`
+	}
+	// ParseError counts records from 1, the ones before it are imported.
+	ParseError struct {
+		Record int
+		Line   int
+		Err    error
+	}
+)
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("record %d on line %d: %v", err.Record, err.Line, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+func ContentType(format string) (string, error) {
+	switch format {
+	case FormatJSONL:
+		return "application/x-ndjson", nil
+	case FormatCSV:
+		return "text/csv; charset=utf-8", nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+func Export(ctx context.Context, store storage.StoreHandler, w io.Writer, format string) (int, error) {
+	var write func(storage.URLStore) error
+	var flush func() error
+	switch format {
+	case FormatJSONL:
+		buf := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buf)
+		write = func(item storage.URLStore) error {
+			item.UUID = 0
+			return encoder.Encode(item)
+		}
+		flush = buf.Flush
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		write = func(item storage.URLStore) error {
+			var ruleSet, split, tags []byte
+			var err error
+			if len(item.Rules) > 0 {
+				if ruleSet, err = json.Marshal(item.Rules); err != nil {
+					return err
+				}
+			}
+			if len(item.Split) > 0 {
+				if split, err = json.Marshal(item.Split); err != nil {
+					return err
+				}
+			}
+			if len(item.Tags) > 0 {
+				if tags, err = json.Marshal(item.Tags); err != nil {
+					return err
+				}
+			}
+			return writer.Write([]string{
+				item.ShortURL,
+				item.OriginalURL,
+				item.UserID,
+				strconv.FormatBool(item.DeletedFlag),
+				strconv.FormatBool(item.Disabled),
+				item.CreatedAt.Format(time.RFC3339Nano),
+				item.Domain,
+				item.Passthrough,
+				strconv.FormatBool(item.Prefix),
+				string(ruleSet),
+				string(split),
+				item.PasswordHash,
+				strconv.Itoa(item.MaxClicks),
+				strconv.Itoa(item.Clicks),
+				item.Title,
+				item.Description,
+				string(tags),
+			})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return 0, ErrUnknownFormat
+	}
+
+	count := 0
+	err := store.ExportURLs(ctx, func(item storage.URLStore) error {
+		count++
+		return write(item)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, flush()
+}
+
+func Import(ctx context.Context, store storage.StoreHandler, r io.Reader, format string) (Result, error) {
+	var next func() (storage.URLStore, int, error)
+	switch format {
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxJSONLine)
+		line := 0
+		next = func() (item storage.URLStore, _ int, err error) {
+			for scanner.Scan() {
+				line++
+				if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+					continue
+				}
+				return item, line, json.Unmarshal(scanner.Bytes(), &item)
+			}
+			if err := scanner.Err(); err != nil {
+				return item, line + 1, err
+			}
+			return item, line, io.EOF
+		}
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err == io.EOF {
+			return Result{}, nil
+		} else if err != nil {
+			return Result{}, &ParseError{Record: 0, Line: 1, Err: fmt.Errorf("read header: %w", err)}
+		}
+		if err := checkHeader(header); err != nil {
+			return Result{}, &ParseError{Record: 0, Line: 1, Err: err}
+		}
+		next = func() (storage.URLStore, int, error) {
+			record, err := reader.Read()
+			var csvErr *csv.ParseError
+			if errors.As(err, &csvErr) {
+				return storage.URLStore{}, csvErr.StartLine, csvErr.Err
+			}
+			if err != nil {
+				return storage.URLStore{}, 0, err
+			}
+			line, _ := reader.FieldPos(0)
+			item, err := parseCSV(record)
+			return item, line, err
+		}
+	default:
+		return Result{}, ErrUnknownFormat
+	}
+
+	var result Result
+	batch := make([]storage.URLStore, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		imported, err := store.ImportURLs(ctx, batch)
+		result.Imported += imported
+		result.Skipped += len(batch) - imported
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		item, line, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = validRecord(item)
+		}
+		if err != nil {
+			if flushErr := flush(); flushErr != nil {
+				return result, flushErr
+			}
+			return result, &ParseError{Record: result.Read + 1, Line: line, Err: err}
+		}
+
+		result.Read++
+		batch = append(batch, storage.URLStore{
+			ShortURL:     item.ShortURL,
+			OriginalURL:  item.OriginalURL,
+			UserID:       item.UserID,
+			DeletedFlag:  item.DeletedFlag,
+			Disabled:     item.Disabled,
+			CreatedAt:    item.CreatedAt,
+			Domain:       item.Domain,
+			Passthrough:  item.Passthrough,
+			Prefix:       item.Prefix,
+			Rules:        item.Rules,
+			Split:        item.Split,
+			PasswordHash: item.PasswordHash,
+			MaxClicks:    item.MaxClicks,
+			Clicks:       item.Clicks,
+			Title:        item.Title,
+			Description:  item.Description,
+			Tags:         item.Tags,
+		})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, flush()
+}
+
+func parseCSV(record []string) (item storage.URLStore, err error) {
+	item.ShortURL = record[0]
+	item.OriginalURL = record[1]
+	item.UserID = record[2]
+	if item.DeletedFlag, err = strconv.ParseBool(record[3]); err != nil {
+		return item, fmt.Errorf("is_deleted: %w", err)
+	}
+	if item.Disabled, err = strconv.ParseBool(record[4]); err != nil {
+		return item, fmt.Errorf("is_disabled: %w", err)
+	}
+	if record[5] != "" {
+		if item.CreatedAt, err = time.Parse(time.RFC3339Nano, record[5]); err != nil {
+			return item, fmt.Errorf("created_at: %w", err)
+		}
+	}
+	if len(record) > 6 {
+		item.Domain = record[6]
+	}
+	if len(record) > 7 {
+		item.Passthrough = record[7]
+	}
+	if len(record) > 8 {
+		if item.Prefix, err = strconv.ParseBool(record[8]); err != nil {
+			return item, fmt.Errorf("is_prefix: %w", err)
+		}
+	}
+	if len(record) > 9 && record[9] != "" {
+		if err = json.Unmarshal([]byte(record[9]), &item.Rules); err != nil {
+			return item, fmt.Errorf("rules: %w", err)
+		}
+	}
+	if len(record) > 10 && record[10] != "" {
+		if err = json.Unmarshal([]byte(record[10]), &item.Split); err != nil {
+			return item, fmt.Errorf("split: %w", err)
+		}
+	}
+	if len(record) > 11 {
+		item.PasswordHash = record[11]
+	}
+	if len(record) > 12 {
+		if item.MaxClicks, err = strconv.Atoi(record[12]); err != nil {
+			return item, fmt.Errorf("max_clicks: %w", err)
+		}
+	}
+	if len(record) > 13 {
+		if item.Clicks, err = strconv.Atoi(record[13]); err != nil {
+			return item, fmt.Errorf("clicks: %w", err)
+		}
+	}
+	if len(record) > 14 {
+		item.Title = record[14]
+	}
+	if len(record) > 15 {
+		item.Description = record[15]
+	}
+	if len(record) > 16 && record[16] != "" {
+		if err = json.Unmarshal([]byte(record[16]), &item.Tags); err != nil {
+			return item, fmt.Errorf("tags: %w", err)
+		}
+	}
+	return item, nil
+}
+
+func checkHeader(header []string) error {
+	if len(header) < minCSVColumns || len(header) > len(csvHeader) {
+		return fmt.Errorf("header has %d columns, expected %d", len(header), len(csvHeader))
+	}
+	for i, name := range header {
+		if name != csvHeader[i] {
+			return fmt.Errorf("column %d is %q, expected %q", i+1, name, csvHeader[i])
+		}
+	}
+	return nil
+}
+
+func validRecord(item storage.URLStore) error {
+	if item.ShortURL == "" {
+		return errors.New("short_url is required")
+	}
+	if err := validate.Code(item.ShortURL); err != nil {
+		return fmt.Errorf("short_url %q: %w", item.ShortURL, err)
+	}
+	if _, err := url.ParseRequestURI(item.OriginalURL); err != nil {
+		return fmt.Errorf("invalid original_url: %s", item.OriginalURL)
+	}
+	if err := redirect.ValidPassthrough(item.Passthrough); err != nil {
+		return err
+	}
+	if err := rules.Validate(item.Rules); err != nil {
+		return err
+	}
+	if err := rules.ValidateSplit(item.Split); err != nil {
+		return err
+	}
+	if item.MaxClicks < 0 || item.Clicks < 0 {
+		return errors.New("max_clicks and clicks must not be negative")
+	}
+	if item.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(item.PasswordHash)); err != nil {
+			return fmt.Errorf("password_hash: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newStore(t *testing.T) storage.StoreHandler {
+	cfg := config.Default()
+	cfg.FileStoragePath = t.TempDir() + "/urls.json"
+	return &storage.MemoryStore{Config: config.New(cfg)}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			source := newStore(t)
+			_, err := source.Save(context.TODO(), storage.URLStore{
+				Domain:      "go.example.com",
+				OriginalURL: "https://practicum.yandex.ru",
+				UserID:      "owner",
+			})
+			require.NoError(t, err)
+			result, err := source.Save(context.TODO(), storage.URLStore{OriginalURL: "https://ya.ru"})
+			require.NoError(t, err)
+			id := result.ShortURL
+			disabled := true
+			ruleSet := []rules.Rule{{Devices: []string{rules.DeviceIOS}, Destination: "https://apps.apple.com"}}
+			split := []rules.Variant{{Destination: "https://ya.ru/a", Weight: 1}, {Destination: "https://ya.ru/b", Weight: 2}}
+			hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+			require.NoError(t, err)
+			passwordHash, maxClicks := string(hash), 3
+			title, description, tags := "Docs, \"home\"", "Line one\nline two", []string{"docs", "team:web"}
+			_, err = source.UpdateURL(context.TODO(), "", id, storage.URLUpdate{Disabled: &disabled, Rules: &ruleSet,
+				Split: &split, PasswordHash: &passwordHash, MaxClicks: &maxClicks, Title: &title,
+				Description: &description, Tags: &tags})
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			count, err := Export(context.TODO(), source, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+
+			target := newStore(t)
+			imported, err := Import(context.TODO(), target, bytes.NewReader(buf.Bytes()), format)
+			require.NoError(t, err)
+			assert.Equal(t, Result{Read: 2, Imported: 2}, imported)
+
+			original, err := source.GetURL(context.TODO(), "", id)
+			require.NoError(t, err)
+			copied, err := target.GetURL(context.TODO(), "", id)
+			require.NoError(t, err)
+			assert.Equal(t, original.OriginalURL, copied.OriginalURL)
+			assert.True(t, copied.Disabled)
+			assert.Equal(t, ruleSet, copied.Rules)
+			assert.Equal(t, split, copied.Split)
+			assert.Equal(t, passwordHash, copied.PasswordHash)
+			assert.Equal(t, maxClicks, copied.MaxClicks)
+			assert.Equal(t, title, copied.Title)
+			assert.Equal(t, description, copied.Description)
+			assert.Equal(t, tags, copied.Tags)
+			assert.True(t, original.CreatedAt.Equal(copied.CreatedAt))
+
+			owned, err := target.GetUserURLs(context.TODO(), "owner", storage.URLFilter{})
+			require.NoError(t, err)
+			require.Len(t, owned.URLs, 1)
+			assert.Equal(t, "go.example.com", owned.URLs[0].Domain)
+
+			again, err := Import(context.TODO(), target, bytes.NewReader(buf.Bytes()), format)
+			require.NoError(t, err)
+			assert.Equal(t, Result{Read: 2, Skipped: 2}, again)
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		want   Result
+		line   int
+	}{
+		{"jsonl without short code", FormatJSONL,
+			`{"short_url":"abc","original_url":"https://ya.ru"}` + "\n\n" + `{"original_url":"https://ya.ru"}`,
+			Result{Read: 1, Imported: 1}, 3},
+		{"jsonl with invalid URL", FormatJSONL, `{"short_url":"abc","original_url":"ya.ru"}`, Result{}, 1},
+		{"jsonl with invalid short code", FormatJSONL, `{"short_url":"a/b","original_url":"https://ya.ru"}`,
+			Result{}, 1},
+		{"jsonl with reserved short code", FormatJSONL, `{"short_url":"api","original_url":"https://ya.ru"}`,
+			Result{}, 1},
+		{"csv with invalid flag", FormatCSV,
+			"short_url,original_url,user_id,is_deleted,is_disabled,created_at\nabc,https://ya.ru,,false,false,\n" +
+				"def,https://ya.ru,,maybe,false,\n",
+			Result{Read: 1, Imported: 1}, 3},
+		{"csv with invalid short code", FormatCSV,
+			"short_url,original_url,user_id,is_deleted,is_disabled,created_at\n\"a b\",https://ya.ru,,false,false,\n",
+			Result{}, 2},
+		{"csv with missing column", FormatCSV, "short_url,original_url\nabc,https://ya.ru\n", Result{}, 1},
+		{"csv with swapped columns", FormatCSV,
+			"original_url,short_url,user_id,is_deleted,is_disabled,created_at\nhttps://ya.ru,abc,,false,false,\n",
+			Result{}, 1},
+		{"csv with short row", FormatCSV,
+			"short_url,original_url,user_id,is_deleted,is_disabled,created_at\nabc,https://ya.ru\n", Result{}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Import(context.TODO(), newStore(t), strings.NewReader(tt.body), tt.format)
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.line, parseErr.Line)
+			assert.Equal(t, tt.want, result)
+		})
+	}
+
+	_, err := Import(context.TODO(), newStore(t), strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
@@ -0,0 +1,161 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	// DB reads CSV lines like 203.0.113.0/24,NL or the DB-IP lite layout.
+	DB struct {
+		ranges []addrRange
+	}
+	addrRange struct {
+		first, last netip.Addr
+		country     string
+	}
+
+	Locator struct {
+		db atomic.Pointer[DB]
+	}
+)
+
+func Open(path string) (*DB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func Parse(r io.Reader) (*DB, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	db := &DB{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		entry, err := parseRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		db.ranges = append(db.ranges, entry)
+	}
+
+	slices.SortFunc(db.ranges, func(a, b addrRange) int {
+		return a.first.Compare(b.first)
+	})
+	return db, nil
+}
+
+func parseRecord(record []string) (addrRange, error) {
+	var entry addrRange
+	switch len(record) {
+	case 2:
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			return entry, err
+		}
+		prefix = prefix.Masked()
+		entry.first, entry.last = prefix.Addr(), lastAddr(prefix)
+	case 3:
+		var err error
+		if entry.first, err = netip.ParseAddr(strings.TrimSpace(record[0])); err != nil {
+			return entry, err
+		}
+		if entry.last, err = netip.ParseAddr(strings.TrimSpace(record[1])); err != nil {
+			return entry, err
+		}
+		if entry.first.Is4() != entry.last.Is4() || entry.last.Less(entry.first) {
+			return entry, fmt.Errorf("invalid range %s-%s", entry.first, entry.last)
+		}
+	default:
+		return entry, fmt.Errorf("expected 2 or 3 fields, got %d", len(record))
+	}
+
+	entry.country = strings.ToUpper(strings.TrimSpace(record[len(record)-1]))
+	if len(entry.country) != 2 {
+		return entry, errors.New("country must be a two-letter code")
+	}
+	return entry, nil
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	for i := bits; i < 128; i++ {
+		addr[i/8] |= 1 << (7 - i%8)
+	}
+	last := netip.AddrFrom16(addr)
+	if prefix.Addr().Is4() {
+		return last.Unmap()
+	}
+	return last
+}
+
+func (db *DB) Country(addr netip.Addr) string {
+	if db == nil || !addr.IsValid() {
+		return ""
+	}
+	addr = addr.Unmap()
+	i, found := slices.BinarySearchFunc(db.ranges, addr, func(entry addrRange, target netip.Addr) int {
+		return entry.first.Compare(target)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || db.ranges[i].last.Less(addr) {
+		return ""
+	}
+	return db.ranges[i].country
+}
+
+func NewLocator(path string) (*Locator, error) {
+	locator := &Locator{}
+	return locator, locator.Load(path)
+}
+
+// Load keeps the old database when the new one can not be read.
+func (l *Locator) Load(path string) error {
+	if path == "" {
+		l.db.Store(nil)
+		return nil
+	}
+	db, err := Open(path)
+	if err != nil {
+		return err
+	}
+	l.db.Store(db)
+	return nil
+}
+
+func (l *Locator) Country(ip string) string {
+	if l == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	return l.db.Load().Country(addr)
+}
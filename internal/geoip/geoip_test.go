@@ -0,0 +1,67 @@
+package geoip
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDB = `# test ranges
+198.51.100.0,198.51.100.255,de
+203.0.113.0/25,NL
+2001:db8::/32,FR
+192.0.2.10,192.0.2.20,US
+`
+
+func TestCountry(t *testing.T) {
+	path := t.TempDir() + "/countries.csv"
+	require.NoError(t, os.WriteFile(path, []byte(testDB), 0600))
+	locator, err := NewLocator(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"198.51.100.0", "DE"},
+		{"198.51.100.255", "DE"},
+		{"203.0.113.127", "NL"},
+		{"203.0.113.128", ""},
+		{"192.0.2.15", "US"},
+		{"192.0.2.21", ""},
+		{"::ffff:198.51.100.7", "DE"},
+		{"2001:db8::1", "FR"},
+		{"2001:db9::1", ""},
+		{"10.0.0.1", ""},
+		{"not an address", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, locator.Country(tt.ip))
+		})
+	}
+
+	require.Error(t, locator.Load(t.TempDir()+"/missing.csv"))
+	assert.Equal(t, "DE", locator.Country("198.51.100.1"))
+	require.NoError(t, locator.Load(""))
+	assert.Equal(t, "", locator.Country("198.51.100.1"))
+
+	var missing *Locator
+	assert.Equal(t, "", missing.Country("198.51.100.1"))
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, body := range []string{
+		"198.51.100.0/24",
+		"198.51.100.0/33,DE",
+		"198.51.100.9,198.51.100.1,DE",
+		"198.51.100.0,2001:db8::1,DE",
+		"198.51.100.0/24,Germany",
+	} {
+		_, err := Parse(strings.NewReader(body))
+		assert.Error(t, err, body)
+	}
+}
@@ -0,0 +1,140 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/auth"
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/grpcapi/pb"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/ratelimit"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var methodScopes = map[string]string{
+	pb.Shortener_Shorten_FullMethodName:      auth.ScopeShorten,
+	pb.Shortener_ShortenBatch_FullMethodName: auth.ScopeBatch,
+	pb.Shortener_ListUserURLs_FullMethodName: auth.ScopeStats,
+	pb.Shortener_DeleteURLs_FullMethodName:   auth.ScopeDelete,
+}
+
+var methodLimits = map[string]string{
+	pb.Shortener_Shorten_FullMethodName:      "create",
+	pb.Shortener_ShortenBatch_FullMethodName: "create",
+	pb.Shortener_Expand_FullMethodName:       "redirect",
+}
+
+func LoggingInterceptor(base *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		requestID := logger.EnsureRequestID(firstMetadata(ctx, strings.ToLower(logger.RequestIDHeader)))
+		grpc.SetHeader(ctx, metadata.Pairs(logger.RequestIDHeader, requestID))
+
+		ctx, span := tracing.Start(ctx, info.FullMethod)
+		defer span.End()
+
+		log := base.With(
+			"request_id", requestID,
+			"trace_id", tracing.TraceID(ctx),
+			"span_id", tracing.SpanID(ctx),
+		)
+		ctx = logger.WithContext(logger.WithRequestID(ctx, requestID), log)
+
+		resp, err := handler(ctx, req)
+
+		log.Infow("grpc request",
+			"method", info.FullMethod,
+			"duration", time.Since(start),
+			"code", status.Code(err).String(),
+		)
+		return resp, err
+	}
+}
+
+func AuthInterceptor(store storage.APIKeyStore, cfg *config.Source) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		header := firstMetadata(ctx, "authorization")
+		if header == "" {
+			if cfg.Get().RequireAPIKey {
+				return nil, status.Error(codes.Unauthenticated, "API key required")
+			}
+			return handler(ctx, req)
+		}
+
+		key, err := auth.Authenticate(ctx, store, header)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		if scope, ok := methodScopes[info.FullMethod]; ok && !key.HasScope(scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "API key lacks the %q scope", scope)
+		}
+		return handler(auth.WithAPIKey(ctx, key), req)
+	}
+}
+
+// RateLimitInterceptor has to run after AuthInterceptor to see the key.
+func RateLimitInterceptor(limiters map[string]*ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		limiter, ok := limiters[methodLimits[info.FullMethod]]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		client := "ip:" + peerAddress(ctx)
+		if key, ok := auth.FromContext(ctx); ok {
+			client = "key:" + key.ID
+		}
+		result, _, err := limiter.Take(ctx, client, limitCost(req))
+		if err != nil {
+			logger.FromContext(ctx).Errorw("rate limiter unavailable", "client", client, "error", err)
+			return handler(ctx, req)
+		}
+		if !result.Allowed {
+			grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(ratelimit.Seconds(result.RetryAfter))))
+			return nil, status.Error(codes.ResourceExhausted, "too many requests")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func limitCost(req any) int {
+	if batch, ok := req.(*pb.ShortenBatchRequest); ok {
+		return max(len(batch.GetItems()), 1)
+	}
+	return 1
+}
+
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	address := p.Addr.String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
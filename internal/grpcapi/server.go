@@ -0,0 +1,281 @@
+//go:generate protoc --proto_path=../../proto --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative shortener.proto
+
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/audit"
+	"github.com/alexch365/go-url-shortener/internal/auth"
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/geoip"
+	"github.com/alexch365/go-url-shortener/internal/grpcapi/pb"
+	"github.com/alexch365/go-url-shortener/internal/links"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/ratelimit"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/validate"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	pb.UnimplementedShortenerServer
+	Store     storage.StoreHandler
+	Config    *config.Source
+	Validator *validate.Validator
+	Domains   *domains.Registry
+	GeoIP     *geoip.Locator
+	Limiters  map[string]*ratelimit.Limiter
+}
+
+func NewServer(s *Server, log *zap.SugaredLogger) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		LoggingInterceptor(log),
+		AuthInterceptor(s.Store, s.Config),
+		RateLimitInterceptor(s.Limiters),
+	))
+	pb.RegisterShortenerServer(server, s)
+	return server
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if err := s.validateURL(req.GetUrl()); err != nil {
+		return nil, err
+	}
+	if err := s.reserveQuota(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	key, _ := auth.FromContext(ctx)
+	item, err := s.Store.Save(ctx, storage.URLStore{Domain: s.domain(ctx).Name, OriginalURL: req.GetUrl(), UserID: key.ID})
+	if err != nil {
+		s.refundQuota(ctx, 1)
+	}
+	var conflict storage.ConflictError
+	if errors.As(err, &conflict) {
+		return &pb.ShortenResponse{ShortUrl: s.shortURL(conflict.URL), AlreadyExists: true}, nil
+	}
+	if err != nil {
+		return nil, internalError(ctx, "save url failed", err)
+	}
+	audit.Record(ctx, s.Store, createdEvent(item))
+	return &pb.ShortenResponse{ShortUrl: s.shortURL(item)}, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	if len(req.GetItems()) > s.Config.Get().BatchMaxSize {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"batch size exceeds limit of %d URLs", s.Config.Get().BatchMaxSize)
+	}
+
+	key, _ := auth.FromContext(ctx)
+	domain := s.domain(ctx).Name
+	store := make([]storage.URLStore, 0, len(req.GetItems()))
+	for _, item := range req.GetItems() {
+		if err := s.validateURL(item.GetOriginalUrl()); err != nil {
+			return nil, err
+		}
+		store = append(store, storage.URLStore{
+			CorrelationID: item.GetCorrelationId(),
+			OriginalURL:   item.GetOriginalUrl(),
+			UserID:        key.ID,
+			Domain:        domain,
+		})
+	}
+	if err := s.reserveQuota(ctx, len(store)); err != nil {
+		return nil, err
+	}
+
+	saved, err := s.Store.SaveBatch(ctx, &store)
+	s.refundQuota(ctx, len(store)-len(saved))
+	var conflict storage.ConflictError
+	if errors.As(err, &conflict) {
+		return nil, status.Errorf(codes.AlreadyExists, "URL already shortened: %s", s.shortURL(conflict.URL))
+	}
+	if err != nil {
+		return nil, internalError(ctx, "save batch failed", err)
+	}
+
+	events := make([]storage.Event, 0, len(saved))
+	for _, item := range saved {
+		events = append(events, createdEvent(item))
+	}
+	audit.Record(ctx, s.Store, events...)
+
+	response := &pb.ShortenBatchResponse{}
+	for _, item := range saved {
+		response.Items = append(response.Items, &pb.BatchResult{
+			CorrelationId: item.CorrelationID,
+			ShortUrl:      s.shortURL(item),
+		})
+	}
+	return response, nil
+}
+
+func (s *Server) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	query, err := url.ParseQuery(req.GetQuery())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid query: %s", req.GetQuery())
+	}
+	item, err := links.Lookup(ctx, s.Store, s.domain(ctx).Name, req.GetId(), req.GetPath())
+	if err != nil {
+		return nil, linkError(ctx, req.GetId(), err)
+	}
+	visitor := rules.Visitor{
+		Devices:   rules.Devices(firstMetadata(ctx, "user-agent")),
+		Languages: rules.Languages(firstMetadata(ctx, "accept-language")),
+		Country:   s.GeoIP.Country(peerAddress(ctx)),
+		Time:      time.Now(),
+		Roll:      links.Roll(req.GetVisitorId(), item),
+	}
+	target, err := links.Follow(ctx, s.Store, item, links.Visit{Path: req.GetPath(), Query: query,
+		Password: req.GetPassword(), Visitor: visitor})
+	if err != nil {
+		return nil, linkError(ctx, req.GetId(), err)
+	}
+	return &pb.ExpandResponse{OriginalUrl: target}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	key, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+
+	filter := storage.URLFilter{
+		Tag:    strings.ToLower(strings.TrimSpace(req.GetTag())),
+		Query:  strings.TrimSpace(req.GetQ()),
+		Cursor: req.GetCursor(),
+		Limit:  int(req.GetLimit()),
+	}
+	if filter.Limit == 0 {
+		filter.Limit = storage.DefaultURLLimit
+	}
+	if filter.Limit < 1 || filter.Limit > storage.MaxURLLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", storage.MaxURLLimit)
+	}
+	if req.GetSort() != "" {
+		filter.Sort, filter.Desc = strings.CutPrefix(req.GetSort(), "-")
+		if filter.Sort != storage.SortCreatedAt && filter.Sort != storage.SortClicks {
+			return nil, status.Error(codes.InvalidArgument, "sort must be created_at or clicks, with - for descending")
+		}
+	}
+
+	page, err := s.Store.GetUserURLs(ctx, key.ID, filter)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+	if err != nil {
+		return nil, internalError(ctx, "list user urls failed", err)
+	}
+
+	response := &pb.ListUserURLsResponse{NextCursor: page.NextCursor}
+	for _, item := range page.URLs {
+		response.Urls = append(response.Urls, &pb.UserURL{ShortUrl: s.shortURL(item), OriginalUrl: item.OriginalURL})
+	}
+	return response, nil
+}
+
+func (s *Server) DeleteURLs(ctx context.Context, req *pb.DeleteURLsRequest) (*pb.DeleteURLsResponse, error) {
+	key, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+
+	deleted, err := s.Store.DeleteURLs(ctx, key.ID, req.GetIds())
+	if err != nil {
+		return nil, internalError(ctx, "delete user urls failed", err)
+	}
+
+	events := make([]storage.Event, 0, len(deleted))
+	for _, item := range deleted {
+		events = append(events, storage.Event{Type: storage.EventDeleted, Domain: item.Domain,
+			ShortURL: item.ShortURL, UserID: key.ID})
+	}
+	audit.Record(ctx, s.Store, events...)
+	return &pb.DeleteURLsResponse{}, nil
+}
+
+func linkError(ctx context.Context, id string, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrURLDeleted):
+		return status.Errorf(codes.NotFound, "deleted ID: %s", id)
+	case errors.Is(err, storage.ErrURLNotFound):
+		return status.Errorf(codes.NotFound, "invalid ID: %s", id)
+	case errors.Is(err, storage.ErrURLDisabled):
+		return status.Errorf(codes.FailedPrecondition, "disabled ID: %s", id)
+	case errors.Is(err, links.ErrPasswordRequired), errors.Is(err, links.ErrWrongPassword):
+		return status.Errorf(codes.PermissionDenied, "%v for ID: %s", err, id)
+	}
+	return internalError(ctx, "expand url failed", err)
+}
+
+func (s *Server) reserveQuota(ctx context.Context, n int) error {
+	key, ok := auth.FromContext(ctx)
+	if !ok || n == 0 {
+		return nil
+	}
+	err := s.Store.UseAPIKeyQuota(ctx, key.ID, n)
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return internalError(ctx, "use api key quota failed", err)
+	}
+	return nil
+}
+
+func (s *Server) refundQuota(ctx context.Context, n int) {
+	key, ok := auth.FromContext(ctx)
+	if !ok || n == 0 {
+		return
+	}
+	if err := s.Store.UseAPIKeyQuota(ctx, key.ID, -n); err != nil {
+		logger.FromContext(ctx).Errorw("refund api key quota failed", "key", key.ID, "error", err)
+	}
+}
+
+// domain is taken from the :authority pseudo-header.
+func (s *Server) domain(ctx context.Context) domains.Domain {
+	return s.Domains.ForHost(firstMetadata(ctx, ":authority"))
+}
+
+func (s *Server) shortURL(item storage.URLStore) string {
+	return s.Domains.Get(item.Domain).ShortURL(item.ShortURL)
+}
+
+func (s *Server) validateURL(rawURL string) error {
+	err := s.Validator.URL(rawURL)
+	if errors.Is(err, validate.ErrBlocked) {
+		return status.Errorf(codes.InvalidArgument, "URL is blocked: %s", rawURL)
+	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid URL: %s", rawURL)
+	}
+	return nil
+}
+
+func createdEvent(item storage.URLStore) storage.Event {
+	return storage.Event{
+		Type:     storage.EventCreated,
+		Domain:   item.Domain,
+		ShortURL: item.ShortURL,
+		UserID:   item.UserID,
+		Field:    "original_url",
+		NewValue: item.OriginalURL,
+	}
+}
+
+func internalError(ctx context.Context, message string, err error) error {
+	logger.FromContext(ctx).Errorw(message, "error", err)
+	return status.Error(codes.Internal, message)
+}
@@ -0,0 +1,299 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/auth"
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/grpcapi/pb"
+	"github.com/alexch365/go-url-shortener/internal/ratelimit"
+	"github.com/alexch365/go-url-shortener/internal/redirect"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/validate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestServer(t *testing.T) *Server {
+	cfg := config.Default()
+	cfg.Domains = []string{"https://go.example.com"}
+	dir := t.TempDir()
+	cfg.FileStoragePath = dir + "/urls.json"
+	cfg.APIKeysPath = dir + "/api_keys.json"
+	cfg.EventLogPath = dir + "/events.json"
+	cfg.ClickLogPath = dir + "/clicks.json"
+	source := config.New(cfg)
+
+	registry, err := domains.New(cfg.BaseURL, cfg.PathPrefix, cfg.Domains)
+	require.NoError(t, err)
+	return &Server{Store: &storage.MemoryStore{Config: source}, Config: source, Validator: validate.New(nil),
+		Domains: registry}
+}
+
+func newTestClient(t *testing.T, options ...grpc.DialOption) (pb.ShortenerClient, storage.StoreHandler) {
+	s := newTestServer(t)
+	return serve(t, s, options...), s.Store
+}
+
+func serve(t *testing.T, s *Server, options ...grpc.DialOption) pb.ShortenerClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewServer(s, zap.NewNop().Sugar())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, options...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShortenerClient(conn)
+}
+
+func withKey(t *testing.T, store storage.StoreHandler, scopes ...string) context.Context {
+	token, key, err := auth.NewAPIKey("test", scopes, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAPIKey(context.TODO(), key))
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestShorten(t *testing.T) {
+	t.Parallel()
+	client, _ := newTestClient(t)
+
+	tests := []struct {
+		name string
+		url  string
+		code codes.Code
+	}{
+		{"with valid URL", "https://practicum.yandex.ru", codes.OK},
+		{"with invalid URL", "https//practicum.yandex.ru", codes.InvalidArgument},
+		{"with empty URL", "", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: tt.url})
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Regexp(t, "http://localhost:8080/.{8}$", resp.GetShortUrl())
+			}
+		})
+	}
+}
+
+func TestShortenBatchAndExpand(t *testing.T) {
+	t.Parallel()
+	client, _ := newTestClient(t)
+
+	resp, err := client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://practicum.yandex.ru"},
+		{CorrelationId: "2", OriginalUrl: "https://ya.ru"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetItems(), 2)
+	assert.Equal(t, "2", resp.GetItems()[1].GetCorrelationId())
+
+	id := resp.GetItems()[1].GetShortUrl()[strings.LastIndex(resp.GetItems()[1].GetShortUrl(), "/")+1:]
+	expanded, err := client.Expand(context.Background(), &pb.ExpandRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", expanded.GetOriginalUrl())
+
+	_, err = client.Expand(context.Background(), &pb.ExpandRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+type failingStore struct {
+	storage.StoreHandler
+}
+
+func (failingStore) Save(context.Context, storage.URLStore) (storage.URLStore, error) {
+	return storage.URLStore{}, errors.New("pq: connection to 10.0.0.5 refused")
+}
+
+func TestErrorCodes(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	client := serve(t, s)
+	_, err := s.Store.Save(context.Background(), storage.URLStore{ShortURL: "off", OriginalURL: "https://go.dev/off",
+		Disabled: true})
+	require.NoError(t, err)
+	_, err = client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://go.dev"})
+	require.NoError(t, err)
+
+	_, err = client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://go.dev"},
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.Expand(context.Background(), &pb.ExpandRequest{Id: "off"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	failing := newTestServer(t)
+	failing.Store = failingStore{failing.Store}
+	_, err = serve(t, failing).Shorten(context.Background(), &pb.ShortenRequest{Url: "https://go.dev"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "10.0.0.5")
+}
+
+func TestExpandFollowsLink(t *testing.T) {
+	t.Parallel()
+	client, store := newTestClient(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	_, err = store.Save(context.Background(), storage.URLStore{ShortURL: "docs", OriginalURL: "https://go.dev/{path}",
+		Prefix: true, Passthrough: redirect.PassthroughMerge, PasswordHash: string(hash)})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  *pb.ExpandRequest
+		code codes.Code
+		want string
+	}{
+		{"without password", &pb.ExpandRequest{Id: "docs"}, codes.PermissionDenied, ""},
+		{"wrong password", &pb.ExpandRequest{Id: "docs", Password: "guess"}, codes.PermissionDenied, ""},
+		{"invalid query", &pb.ExpandRequest{Id: "docs", Query: "a=%zz"}, codes.InvalidArgument, ""},
+		{"path and query", &pb.ExpandRequest{Id: "docs", Path: "doc", Query: "a=1", Password: "secret"}, codes.OK,
+			"https://go.dev/doc?a=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Expand(context.Background(), tt.req)
+			require.Equal(t, tt.code, status.Code(err), err)
+			assert.Equal(t, tt.want, resp.GetOriginalUrl())
+		})
+	}
+}
+
+func TestDomain(t *testing.T) {
+	t.Parallel()
+	branded, _ := newTestClient(t, grpc.WithAuthority("go.example.com"))
+
+	resp, err := branded.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://ya.ru"})
+	require.NoError(t, err)
+	assert.Regexp(t, "^https://go.example.com/.{8}$", resp.GetShortUrl())
+
+	id := resp.GetShortUrl()[strings.LastIndex(resp.GetShortUrl(), "/")+1:]
+	expanded, err := branded.Expand(context.Background(), &pb.ExpandRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", expanded.GetOriginalUrl())
+}
+
+func TestUserURLs(t *testing.T) {
+	t.Parallel()
+	client, store := newTestClient(t)
+	ctx := withKey(t, store, auth.ScopeShorten, auth.ScopeStats, auth.ScopeDelete)
+	shortenOnly := withKey(t, store, auth.ScopeShorten)
+
+	_, err := client.ListUserURLs(context.Background(), &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.ListUserURLs(shortenOnly, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	invalid := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer sk_invalid")
+	_, err = client.Shorten(invalid, &pb.ShortenRequest{Url: "https://ya.ru"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	shortened, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, shortened.GetShortUrl(), list.GetUrls()[0].GetShortUrl())
+
+	id := shortened.GetShortUrl()[strings.LastIndex(shortened.GetShortUrl(), "/")+1:]
+	_, err = client.DeleteURLs(ctx, &pb.DeleteURLsRequest{Ids: []string{id}})
+	require.NoError(t, err)
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.GetUrls())
+}
+
+func TestListUserURLsPages(t *testing.T) {
+	t.Parallel()
+	client, store := newTestClient(t)
+	ctx := withKey(t, store, auth.ScopeShorten, auth.ScopeStats)
+	for _, rawURL := range []string{"https://go.dev/doc", "https://go.dev/blog", "https://ya.ru"} {
+		_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: rawURL})
+		require.NoError(t, err)
+	}
+
+	first, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Limit: 2, Sort: "-created_at"})
+	require.NoError(t, err)
+	require.Len(t, first.GetUrls(), 2)
+	assert.Equal(t, "https://ya.ru", first.GetUrls()[0].GetOriginalUrl())
+	require.NotEmpty(t, first.GetNextCursor())
+
+	second, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Limit: 2, Sort: "-created_at",
+		Cursor: first.GetNextCursor()})
+	require.NoError(t, err)
+	require.Len(t, second.GetUrls(), 1)
+	assert.Equal(t, "https://go.dev/doc", second.GetUrls()[0].GetOriginalUrl())
+	assert.Empty(t, second.GetNextCursor())
+
+	found, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Q: "go.dev"})
+	require.NoError(t, err)
+	assert.Len(t, found.GetUrls(), 2)
+
+	for _, req := range []*pb.ListUserURLsRequest{
+		{Limit: 1001},
+		{Limit: -1},
+		{Sort: "title"},
+		{Cursor: "bogus"},
+	} {
+		_, err := client.ListUserURLs(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), req.String())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	backend := ratelimit.NewMemoryBackend()
+	s.Limiters = map[string]*ratelimit.Limiter{
+		"create":   ratelimit.NewLimiter(backend, ratelimit.PerPeriod("create", 2, time.Minute)),
+		"redirect": ratelimit.NewLimiter(backend, ratelimit.PerPeriod("redirect", 0, time.Minute)),
+	}
+	client := serve(t, s)
+
+	var id string
+	for range 2 {
+		resp, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://practicum.yandex.ru"})
+		require.NoError(t, err)
+		id = resp.GetShortUrl()[strings.LastIndex(resp.GetShortUrl(), "/")+1:]
+	}
+	var trailer metadata.MD
+	_, err := client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(t, trailer.Get("retry-after"))
+
+	ctx := withKey(t, s.Store, auth.ScopeShorten)
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru"})
+	assert.NoError(t, err, "API keys have their own budget")
+	for range 3 {
+		_, err = client.Expand(context.Background(), &pb.ExpandRequest{Id: id})
+		assert.NoError(t, err, "a zero limit is off")
+	}
+}
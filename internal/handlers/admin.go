@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/audit"
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/dump"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/redirect"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+func (h *Handler) AdminFindURLs(w http.ResponseWriter, req *http.Request) {
+	originalURL := req.URL.Query().Get("original_url")
+	if originalURL == "" {
+		respond(w, req, problem.New(problem.Validation, "Query parameter original_url is required."))
+		return
+	}
+
+	urls, err := h.Store.FindURLs(req.Context(), originalURL)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	response := make([]adminURL, 0, len(urls))
+	for _, item := range urls {
+		response = append(response, h.newAdminURL(item))
+	}
+	util.JSONResponse(w, response, http.StatusOK)
+}
+
+func (h *Handler) adminDomain(req *http.Request) (domains.Domain, error) {
+	name := req.URL.Query().Get("domain")
+	domain, ok := h.Domains.Lookup(name)
+	if !ok {
+		return domain, problem.New(problem.NotFound, "Unknown domain: %s", name)
+	}
+	return domain, nil
+}
+
+func (h *Handler) AdminGetURL(w http.ResponseWriter, req *http.Request) {
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	item, err := h.Store.GetURL(req.Context(), domain.Name, chi.URLParam(req, "id"))
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	util.JSONResponse(w, h.newAdminURL(item), http.StatusOK)
+}
+
+// AdminUpdateURL records one event per changed field, password changes without
+// the values.
+func (h *Handler) AdminUpdateURL(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		storage.URLUpdate
+		Password *string `json:"password,omitempty"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid request format."))
+		return
+	}
+	update := body.URLUpdate
+	if update.OriginalURL == nil && update.UserID == nil && update.Disabled == nil && update.Passthrough == nil &&
+		update.Prefix == nil && update.MaxClicks == nil && update.Clicks == nil && body.Password == nil &&
+		update.Title == nil && update.Description == nil && update.Tags == nil {
+		respond(w, req, problem.New(problem.Validation, "Nothing to update."))
+		return
+	}
+	if body.Password != nil {
+		hash, err := hashPassword(*body.Password)
+		if err != nil {
+			respond(w, req, err)
+			return
+		}
+		update.PasswordHash = &hash
+	}
+	for _, n := range []*int{update.MaxClicks, update.Clicks} {
+		if n == nil {
+			continue
+		}
+		if err := validClicks(*n); err != nil {
+			respond(w, req, err)
+			return
+		}
+	}
+	if update.Title != nil {
+		if err := validText("Title", *update.Title, maxTitleLength); err != nil {
+			respond(w, req, err)
+			return
+		}
+	}
+	if update.Description != nil {
+		if err := validText("Description", *update.Description, maxDescriptionLength); err != nil {
+			respond(w, req, err)
+			return
+		}
+	}
+	if update.Tags != nil {
+		tags, err := normalizeTags(*update.Tags)
+		if err != nil {
+			respond(w, req, err)
+			return
+		}
+		update.Tags = &tags
+	}
+	if update.OriginalURL != nil {
+		if err := h.Validator.URL(*update.OriginalURL); err != nil {
+			respond(w, req, urlProblem(err, *update.OriginalURL))
+			return
+		}
+	}
+	if update.Passthrough != nil {
+		if err := redirect.ValidPassthrough(*update.Passthrough); err != nil {
+			respond(w, req, problem.Wrap(problem.Validation, err, "Passthrough must be ignore, merge or override."))
+			return
+		}
+	}
+
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	id := chi.URLParam(req, "id")
+	before, err := h.Store.GetURL(req.Context(), domain.Name, id)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	after, err := h.Store.UpdateURL(req.Context(), domain.Name, id, update)
+	var conflict storage.ConflictError
+	if errors.As(err, &conflict) {
+		respond(w, req, h.conflictProblem(conflict))
+		return
+	}
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	var events []storage.Event
+	if before.OriginalURL != after.OriginalURL {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "original_url", OldValue: before.OriginalURL, NewValue: after.OriginalURL})
+	}
+	if before.UserID != after.UserID {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "user_id", OldValue: before.UserID, NewValue: after.UserID})
+	}
+	if before.Disabled != after.Disabled {
+		eventType := storage.EventEnabled
+		if after.Disabled {
+			eventType = storage.EventDisabled
+		}
+		events = append(events, storage.Event{Type: eventType, Domain: after.Domain, ShortURL: id, UserID: after.UserID})
+	}
+	if before.Passthrough != after.Passthrough {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "passthrough", OldValue: before.Passthrough, NewValue: after.Passthrough})
+	}
+	if before.Prefix != after.Prefix {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "is_prefix", OldValue: strconv.FormatBool(before.Prefix), NewValue: strconv.FormatBool(after.Prefix)})
+	}
+	if before.PasswordHash != after.PasswordHash {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "password"})
+	}
+	if before.MaxClicks != after.MaxClicks {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "max_clicks", OldValue: strconv.Itoa(before.MaxClicks), NewValue: strconv.Itoa(after.MaxClicks)})
+	}
+	if before.Clicks != after.Clicks {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "clicks", OldValue: strconv.Itoa(before.Clicks), NewValue: strconv.Itoa(after.Clicks)})
+	}
+	if before.Title != after.Title {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "title", OldValue: before.Title, NewValue: after.Title})
+	}
+	if before.Description != after.Description {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "description", OldValue: before.Description, NewValue: after.Description})
+	}
+	if !slices.Equal(before.Tags, after.Tags) {
+		events = append(events, storage.Event{Type: storage.EventUpdated, Domain: after.Domain, ShortURL: id, UserID: after.UserID,
+			Field: "tags", OldValue: strings.Join(before.Tags, ","), NewValue: strings.Join(after.Tags, ",")})
+	}
+	audit.Record(req.Context(), h.Store, events...)
+	util.JSONResponse(w, h.newAdminURL(after), http.StatusOK)
+}
+
+func (h *Handler) AdminDeleteURL(w http.ResponseWriter, req *http.Request) {
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	id := chi.URLParam(req, "id")
+	before, err := h.Store.GetURL(req.Context(), domain.Name, id)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	if err := h.Store.DeleteURL(req.Context(), domain.Name, id); err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	audit.Record(req.Context(), h.Store, storage.Event{Type: storage.EventPurged, Domain: before.Domain, ShortURL: id,
+		UserID: before.UserID, Field: "original_url", OldValue: before.OriginalURL})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) AdminEvents(w http.ResponseWriter, req *http.Request) {
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	query := req.URL.Query()
+	filter := storage.EventFilter{
+		Domain:   domain.Name,
+		UserID:   query.Get("user_id"),
+		ShortURL: query.Get("short_url"),
+		Cursor:   query.Get("cursor"),
+		Limit:    defaultEventLimit,
+	}
+
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			respond(w, req, problem.Wrap(problem.Validation, err, "Invalid from: "+value))
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			respond(w, req, problem.Wrap(problem.Validation, err, "Invalid to: "+value))
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxEventLimit {
+			respond(w, req, problem.New(problem.Validation,
+				"Limit must be between 1 and %d.", maxEventLimit))
+			return
+		}
+	}
+
+	page, err := h.Store.ListEvents(req.Context(), filter)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid cursor."))
+		return
+	}
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	nextLink(w, req, page.NextCursor)
+	if page.Events == nil {
+		page.Events = []storage.Event{}
+	}
+	util.JSONResponse(w, page.Events, http.StatusOK)
+}
+
+func (h *Handler) AdminExport(w http.ResponseWriter, req *http.Request) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = dump.FormatJSONL
+	}
+	contentType, err := dump.ContentType(format)
+	if err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Format must be jsonl or csv."))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="urls.`+format+`"`)
+	count, err := dump.Export(req.Context(), h.Store, w, format)
+	if err != nil {
+		// The status line is already sent, a truncated body is all we can signal.
+		logger.FromContext(req.Context()).Errorw("export failed", "exported", count, "error", err)
+		return
+	}
+	logger.FromContext(req.Context()).Infow("links exported", "format", format, "count", count)
+}
+
+func (h *Handler) AdminImport(w http.ResponseWriter, req *http.Request) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = dump.FormatJSONL
+		if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "text/csv" {
+			format = dump.FormatCSV
+		}
+	}
+	if _, err := dump.ContentType(format); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Format must be jsonl or csv."))
+		return
+	}
+
+	result, err := dump.Import(req.Context(), h.Store, req.Body, format)
+	var parseErr *dump.ParseError
+	if errors.As(err, &parseErr) {
+		respond(w, req, problem.Wrap(problem.Validation, err, parseErr.Error()).
+			With("imported", result.Imported).With("skipped", result.Skipped))
+		return
+	}
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	logger.FromContext(req.Context()).Infow("links imported",
+		"format", format, "read", result.Read, "imported", result.Imported, "skipped", result.Skipped)
+	util.JSONResponse(w, result, http.StatusOK)
+}
+
+func (h *Handler) AdminDomains(w http.ResponseWriter, _ *http.Request) {
+	util.JSONResponse(w, h.Domains.List(), http.StatusOK)
+}
+
+func (h *Handler) AdminMigration(w http.ResponseWriter, req *http.Request) {
+	migrating, ok := h.Store.(*storage.MigratingStore)
+	if !ok {
+		respond(w, req, problem.New(problem.NotFound, "No storage migration is running."))
+		return
+	}
+	util.JSONResponse(w, migrating.Status(), http.StatusOK)
+}
+
+func (h *Handler) AdminReloadConfig(w http.ResponseWriter, req *http.Request) {
+	result, err := h.Config.Reload()
+	if err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, err.Error()))
+		return
+	}
+	logger.FromContext(req.Context()).Infow("config reloaded",
+		"applied", result.Applied, "restart_required", result.RestartRequired)
+	util.JSONResponse(w, result, http.StatusOK)
+}
@@ -4,136 +4,506 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/alexch365/go-url-shortener/internal/audit"
+	"github.com/alexch365/go-url-shortener/internal/auth"
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/geoip"
+	"github.com/alexch365/go-url-shortener/internal/links"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/preview"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/proxy"
+	"github.com/alexch365/go-url-shortener/internal/qrcode"
+	"github.com/alexch365/go-url-shortener/internal/ratelimit"
+	"github.com/alexch365/go-url-shortener/internal/redirect"
+	"github.com/alexch365/go-url-shortener/internal/rules"
 	"github.com/alexch365/go-url-shortener/internal/storage"
 	"github.com/alexch365/go-url-shortener/internal/util"
+	"github.com/alexch365/go-url-shortener/internal/validate"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type (
 	apiRequest struct {
-		URL string `json:"url"`
+		URL         string          `json:"url"`
+		QR          string          `json:"qr,omitempty"`
+		Passthrough string          `json:"passthrough,omitempty"`
+		Code        string          `json:"code,omitempty"`
+		Prefix      bool            `json:"prefix,omitempty"`
+		Split       []rules.Variant `json:"split,omitempty"`
+		Password    string          `json:"password,omitempty"`
+		MaxClicks   int             `json:"max_clicks,omitempty"`
+		Title       string          `json:"title,omitempty"`
+		Description string          `json:"description,omitempty"`
+		Tags        []string        `json:"tags,omitempty"`
 	}
 	apiResponse struct {
 		Result string `json:"result,omitempty"`
-		Error  string `json:"error,omitempty"`
+		QR     string `json:"qr,omitempty"`
 	}
 )
 
-var StoreHandler storage.StoreHandler
+type Handler struct {
+	Store     storage.StoreHandler
+	Config    *config.Source
+	Validator *validate.Validator
+	Domains   *domains.Registry
+	GeoIP     *geoip.Locator
+	Preview   *preview.Fetcher
+	// CreateLimit charges batches one token per URL.
+	CreateLimit *ratelimit.Limiter
+}
 
-func PingDatabase(w http.ResponseWriter, r *http.Request) {
-	handler, ok := StoreHandler.(*storage.DatabaseStore)
+func (h *Handler) PingDatabase(w http.ResponseWriter, r *http.Request) {
+	handler, ok := storage.Database(h.Store)
 	if !ok {
-		http.Error(w, "Database connection failed.", http.StatusInternalServerError)
+		respond(w, r, problem.New(problem.Internal, "Database connection failed."))
 		return
 	}
 
 	if err := handler.DB.PingContext(r.Context()); err != nil {
-		http.Error(w, "Database connection failed.", http.StatusInternalServerError)
+		respond(w, r, problem.Wrap(problem.Internal, err, "Database connection failed."))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func Shorten(w http.ResponseWriter, req *http.Request) {
-	bodyURL, err := parseURLFromBody(req.Body)
+func (h *Handler) Shorten(w http.ResponseWriter, req *http.Request) {
+	bodyURL, err := h.parseURLFromBody(req.Body)
+	if errors.Is(err, validate.ErrBlocked) {
+		respond(w, req, urlProblem(err, bodyURL))
+		return
+	}
 	if err != nil {
-		http.Error(w, "You must provide a valid URL.", http.StatusBadRequest)
+		respond(w, req, problem.Wrap(problem.Validation, err, "You must provide a valid URL."))
 		return
 	}
 
-	result, err := StoreHandler.Save(req.Context(), bodyURL)
+	if err := h.reserveQuota(req, 1); err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	item, err := h.Store.Save(req.Context(), storage.URLStore{
+		Domain:      h.Domains.ForHost(req.Host).Name,
+		OriginalURL: bodyURL,
+		UserID:      userID(req),
+	})
 	if err != nil {
-		if errors.As(err, &storage.ConflictError{}) {
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte(err.(storage.ConflictError).ShortURL))
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		h.refundQuota(req, 1)
+	}
+	var conflict storage.ConflictError
+	if errors.As(err, &conflict) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(h.shortURL(conflict.URL)))
+		return
+	}
+	if err != nil {
+		respond(w, req, err)
 		return
 	}
 
+	audit.Record(req.Context(), h.Store, createdEvent(req, item))
+	h.fetchPreview(req, item)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusCreated)
-	_, err = w.Write([]byte(result))
+	_, err = w.Write([]byte(h.shortURL(item)))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.FromContext(req.Context()).Errorw("write response failed", "error", err)
 	}
 }
 
-func ShortenAPI(w http.ResponseWriter, req *http.Request) {
+func (h *Handler) ShortenAPI(w http.ResponseWriter, req *http.Request) {
 	var requestJSON apiRequest
 	if err := json.NewDecoder(req.Body).Decode(&requestJSON); err != nil {
-		util.JSONResponse(w, apiResponse{Error: "Invalid request format."}, http.StatusBadRequest)
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid request format."))
 		return
 	}
 
-	if _, err := url.ParseRequestURI(requestJSON.URL); err != nil {
-		response := apiResponse{Error: fmt.Sprintf("Invalid URL: %s", requestJSON.URL)}
-		util.JSONResponse(w, response, http.StatusBadRequest)
+	if err := h.Validator.URL(requestJSON.URL); err != nil {
+		respond(w, req, urlProblem(err, requestJSON.URL))
+		return
+	}
+	qrOptions, err := qrcode.ParseOptions(url.Values{"format": {requestJSON.QR}})
+	if err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "QR format must be png or svg."))
+		return
+	}
+	if err := redirect.ValidPassthrough(requestJSON.Passthrough); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Passthrough must be ignore, merge or override."))
+		return
+	}
+	if err := validCode(requestJSON.Code); err != nil {
+		respond(w, req, err)
+		return
+	}
+	if err := h.validSplit(requestJSON.Split); err != nil {
+		respond(w, req, err)
+		return
+	}
+	if err := validClicks(requestJSON.MaxClicks); err != nil {
+		respond(w, req, err)
+		return
+	}
+	passwordHash, err := hashPassword(requestJSON.Password)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	tags, err := validMetadata(requestJSON.Title, requestJSON.Description, requestJSON.Tags)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	if err := h.reserveQuota(req, 1); err != nil {
+		respond(w, req, err)
 		return
 	}
 
-	shortURL, err := StoreHandler.Save(req.Context(), requestJSON.URL)
+	item, err := h.Store.Save(req.Context(), storage.URLStore{
+		Domain:       h.Domains.ForHost(req.Host).Name,
+		OriginalURL:  requestJSON.URL,
+		UserID:       userID(req),
+		Passthrough:  requestJSON.Passthrough,
+		ShortURL:     requestJSON.Code,
+		Prefix:       requestJSON.Prefix,
+		Split:        requestJSON.Split,
+		PasswordHash: passwordHash,
+		MaxClicks:    requestJSON.MaxClicks,
+		Title:        requestJSON.Title,
+		Description:  requestJSON.Description,
+		Tags:         tags,
+	})
 	if err != nil {
-		if errors.As(err, &storage.ConflictError{}) {
-			util.JSONResponse(w, apiResponse{Result: err.(storage.ConflictError).ShortURL}, http.StatusConflict)
-		} else {
-			util.JSONResponse(w, apiResponse{Error: err.Error()}, http.StatusInternalServerError)
-		}
+		h.refundQuota(req, 1)
+	}
+	var conflict storage.ConflictError
+	if errors.As(err, &conflict) {
+		respond(w, req, h.conflictProblem(conflict))
+		return
+	}
+	if err != nil {
+		respond(w, req, err)
 		return
 	}
 
-	util.JSONResponse(w, apiResponse{Result: shortURL}, http.StatusCreated)
+	audit.Record(req.Context(), h.Store, createdEvent(req, item))
+	h.fetchPreview(req, item)
+	response := apiResponse{Result: h.shortURL(item)}
+	if requestJSON.QR != "" {
+		content, err := qrcode.Render(response.Result, qrOptions)
+		if err != nil {
+			respond(w, req, err)
+			return
+		}
+		response.QR = qrcode.DataURI(content, qrOptions)
+	}
+	util.JSONResponse(w, response, http.StatusCreated)
 }
 
-func ShortenAPIBatch(w http.ResponseWriter, req *http.Request) {
+func (h *Handler) ShortenAPIBatch(w http.ResponseWriter, req *http.Request) {
 	var store []storage.URLStore
 	if err := json.NewDecoder(req.Body).Decode(&store); err != nil {
-		util.JSONResponse(w, apiResponse{Error: "Invalid request format."}, http.StatusBadRequest)
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid request format."))
 		return
 	}
 
-	for _, item := range store {
-		if _, err := url.ParseRequestURI(item.OriginalURL); err != nil {
-			response := apiResponse{Error: fmt.Sprintf("Invalid URL: %s", item.OriginalURL)}
-			util.JSONResponse(w, response, http.StatusBadRequest)
+	if len(store) > h.Config.Get().BatchMaxSize {
+		respond(w, req, problem.New(problem.TooLarge,
+			"Batch size exceeds limit of %d URLs.", h.Config.Get().BatchMaxSize))
+		return
+	}
+
+	domain := h.Domains.ForHost(req.Host)
+	for i, item := range store {
+		store[i].UserID = userID(req)
+		store[i].Domain = domain.Name
+		store[i].Rules = nil
+		store[i].Split = nil
+		store[i].PasswordHash = ""
+		store[i].MaxClicks, store[i].Clicks = 0, 0
+		if err := h.Validator.URL(item.OriginalURL); err != nil {
+			respond(w, req, urlProblem(err, item.OriginalURL))
 			return
 		}
+		if err := redirect.ValidPassthrough(item.Passthrough); err != nil {
+			respond(w, req, problem.Wrap(problem.Validation, err, "Passthrough must be ignore, merge or override."))
+			return
+		}
+		tags, err := validMetadata(item.Title, item.Description, item.Tags)
+		if err != nil {
+			respond(w, req, err)
+			return
+		}
+		store[i].Tags = tags
+	}
+
+	if h.CreateLimit != nil && !h.CreateLimit.Allow(w, req, max(len(store), 1)) {
+		return
+	}
+
+	if err := h.reserveQuota(req, len(store)); err != nil {
+		respond(w, req, err)
+		return
 	}
 
-	responseStore, err := StoreHandler.SaveBatch(req.Context(), &store)
+	responseStore, err := h.Store.SaveBatch(req.Context(), &store)
+	h.refundQuota(req, len(store)-len(responseStore))
 	if err != nil {
-		util.JSONResponse(w, apiResponse{Error: err.Error()}, http.StatusBadRequest)
+		respond(w, req, err)
 		return
 	}
 
-	util.JSONResponse(w, responseStore, http.StatusCreated)
+	events := make([]storage.Event, 0, len(responseStore))
+	response := make([]batchResult, 0, len(responseStore))
+	for _, item := range responseStore {
+		events = append(events, createdEvent(req, item))
+		response = append(response, h.newBatchResult(item))
+	}
+	audit.Record(req.Context(), h.Store, events...)
+	h.fetchPreview(req, responseStore...)
+
+	util.JSONResponse(w, response, http.StatusCreated)
 }
 
-func Expand(w http.ResponseWriter, req *http.Request) {
-	urlID := strings.TrimPrefix(req.URL.Path, "/")
-	storedURL, err := StoreHandler.Get(req.Context(), urlID)
+func (h *Handler) Expand(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	id, rest, _ := strings.Cut(path, "/")
+	item, err := links.Lookup(req.Context(), h.Store, h.Domains.ForHost(req.Host).Name, id, rest)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid ID: %s", urlID), http.StatusNotFound)
+		respond(w, req, linkProblem(req, path, err))
+		return
+	}
+	visit := links.Visit{Path: rest, Query: req.URL.Query()}
+	if item.PasswordHash != "" {
+		var ok bool
+		if visit.Password, ok = password(w, req); !ok {
+			return
+		}
+	}
+	visit.Visitor = h.visitor(req)
+	visit.Visitor.Roll = h.roll(w, req, item)
+	target, err := links.Follow(req.Context(), h.Store, item, visit)
+	if errors.Is(err, links.ErrPasswordRequired) || errors.Is(err, links.ErrWrongPassword) {
+		renderPasswordForm(w, req, http.StatusForbidden, "Wrong password.")
+		return
+	}
+	if err != nil {
+		respond(w, req, linkProblem(req, id, err))
 		return
 	}
 
-	w.Header().Set("Location", storedURL)
+	w.Header().Set("Location", target)
+	if req.Method == http.MethodPost {
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
-func parseURLFromBody(body io.ReadCloser) (string, error) {
+func (h *Handler) visitor(req *http.Request) rules.Visitor {
+	return rules.Visitor{
+		Devices:   rules.Devices(req.UserAgent()),
+		Languages: rules.Languages(req.Header.Get("Accept-Language")),
+		Country:   h.GeoIP.Country(proxy.ClientIP(req)),
+		Time:      time.Now(),
+	}
+}
+
+func nextLink(w http.ResponseWriter, req *http.Request, cursor string) {
+	if cursor == "" {
+		return
+	}
+	next := req.URL.Query()
+	next.Set("cursor", cursor)
+	w.Header().Set("Link", fmt.Sprintf(`<?%s>; rel="next"`, next.Encode()))
+}
+
+func (h *Handler) resolve(req *http.Request, urlID string) (storage.URLStore, error) {
+	item, err := h.Store.Get(req.Context(), h.Domains.ForHost(req.Host).Name, urlID)
+	return item, linkProblem(req, urlID, err)
+}
+
+func linkProblem(req *http.Request, urlID string, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrURLDeleted):
+		return problem.Wrap(problem.Gone, err, fmt.Sprintf("Deleted ID: %s", urlID))
+	case errors.Is(err, storage.ErrURLDisabled):
+		return problem.Wrap(problem.Gone, err, fmt.Sprintf("Disabled ID: %s", urlID))
+	case errors.Is(err, storage.ErrURLNotFound):
+		logger.FromContext(req.Context()).Debugw("short url not found", "id", urlID)
+		return problem.Wrap(problem.NotFound, err, fmt.Sprintf("Invalid ID: %s", urlID))
+	}
+	return err
+}
+
+func (h *Handler) UserURLs(w http.ResponseWriter, req *http.Request) {
+	key, ok := auth.FromContext(req.Context())
+	if !ok {
+		respond(w, req, problem.New(problem.Unauthorized, "API key required."))
+		return
+	}
+
+	filter, err := urlFilter(req.URL.Query())
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	page, err := h.Store.GetUserURLs(req.Context(), key.ID, filter)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid cursor."))
+		return
+	}
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	nextLink(w, req, page.NextCursor)
+	if len(page.URLs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	response := make([]userURL, 0, len(page.URLs))
+	for _, item := range page.URLs {
+		entry := h.newUserURL(item)
+		if len(item.Split) > 0 {
+			if entry.Variants, err = h.variantStats(req, item); err != nil {
+				respond(w, req, err)
+				return
+			}
+		}
+		response = append(response, entry)
+	}
+	util.JSONResponse(w, response, http.StatusOK)
+}
+
+func urlFilter(query url.Values) (storage.URLFilter, error) {
+	filter := storage.URLFilter{
+		Tag:    strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Query:  strings.TrimSpace(query.Get("q")),
+		Cursor: query.Get("cursor"),
+		Limit:  storage.DefaultURLLimit,
+	}
+	if value := query.Get("sort"); value != "" {
+		filter.Sort, filter.Desc = strings.CutPrefix(value, "-")
+		if filter.Sort != storage.SortCreatedAt && filter.Sort != storage.SortClicks {
+			return filter, problem.New(problem.Validation, "Sort must be created_at or clicks, with - for descending.")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > storage.MaxURLLimit {
+			return filter, problem.New(problem.Validation, "Limit must be between 1 and %d.", storage.MaxURLLimit)
+		}
+	}
+	return filter, nil
+}
+
+func (h *Handler) DeleteUserURLs(w http.ResponseWriter, req *http.Request) {
+	key, ok := auth.FromContext(req.Context())
+	if !ok {
+		respond(w, req, problem.New(problem.Unauthorized, "API key required."))
+		return
+	}
+
+	var keys []string
+	if err := json.NewDecoder(req.Body).Decode(&keys); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid request format."))
+		return
+	}
+
+	deleted, err := h.Store.DeleteURLs(req.Context(), key.ID, keys)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	events := make([]storage.Event, 0, len(deleted))
+	for _, item := range deleted {
+		events = append(events, storage.Event{Type: storage.EventDeleted, Domain: item.Domain,
+			ShortURL: item.ShortURL, UserID: key.ID})
+	}
+	audit.Record(req.Context(), h.Store, events...)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func userID(req *http.Request) string {
+	key, _ := auth.FromContext(req.Context())
+	return key.ID
+}
+
+func createdEvent(req *http.Request, item storage.URLStore) storage.Event {
+	return storage.Event{
+		Type:     storage.EventCreated,
+		Domain:   item.Domain,
+		ShortURL: item.ShortURL,
+		UserID:   userID(req),
+		Field:    "original_url",
+		NewValue: item.OriginalURL,
+	}
+}
+
+// reserveQuota charges the key before saving so concurrent requests cannot
+// overrun the quota.
+func (h *Handler) reserveQuota(req *http.Request, n int) error {
+	key, ok := auth.FromContext(req.Context())
+	if !ok || n == 0 {
+		return nil
+	}
+	return h.Store.UseAPIKeyQuota(req.Context(), key.ID, n)
+}
+
+func (h *Handler) refundQuota(req *http.Request, n int) {
+	key, ok := auth.FromContext(req.Context())
+	if !ok || n == 0 {
+		return
+	}
+	if err := h.Store.UseAPIKeyQuota(req.Context(), key.ID, -n); err != nil {
+		logger.FromContext(req.Context()).Errorw("refund api key quota failed", "key", key.ID, "error", err)
+	}
+}
+
+func (h *Handler) parseURLFromBody(body io.ReadCloser) (string, error) {
 	defer body.Close()
 	bodyData, err := io.ReadAll(body)
 	if err != nil || len(bodyData) == 0 {
 		return "", errors.New("empty or invalid body")
 	}
 	urlStr := string(bodyData)
-	if _, err := url.ParseRequestURI(urlStr); err != nil {
-		return "", fmt.Errorf("invalid URL: %s", urlStr)
+	if err := h.Validator.URL(urlStr); err != nil {
+		return urlStr, fmt.Errorf("invalid URL: %s: %w", urlStr, err)
 	}
 	return urlStr, nil
 }
+
+func validCode(code string) error {
+	if code == "" {
+		return nil
+	}
+	err := validate.Code(code)
+	if errors.Is(err, validate.ErrReservedCode) {
+		return problem.Wrap(problem.Validation, err, fmt.Sprintf("Short code is reserved: %s", code))
+	}
+	if err != nil {
+		return problem.Wrap(problem.Validation, err,
+			"Short code must be 1 to 64 letters, digits, dashes or underscores.")
+	}
+	return nil
+}
+
+func urlProblem(err error, rawURL string) error {
+	if errors.Is(err, validate.ErrBlocked) {
+		return problem.Wrap(problem.Validation, err, "URL is blocked: "+rawURL)
+	}
+	return problem.Wrap(problem.Validation, err, "Invalid URL: "+rawURL)
+}
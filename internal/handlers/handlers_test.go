@@ -3,22 +3,58 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexch365/go-url-shortener/internal/auth"
 	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/domains"
+	"github.com/alexch365/go-url-shortener/internal/geoip"
+	"github.com/alexch365/go-url-shortener/internal/preview"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/rules"
 	"github.com/alexch365/go-url-shortener/internal/storage"
 	"github.com/alexch365/go-url-shortener/internal/util"
+	"github.com/alexch365/go-url-shortener/internal/validate"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func newTestHandler(t *testing.T, configure ...func(cfg *config.Config)) *Handler {
+	t.Helper()
+	cfg := config.Default()
+	dir := t.TempDir()
+	cfg.FileStoragePath = dir + "/urls.json"
+	cfg.APIKeysPath = dir + "/api_keys.json"
+	cfg.EventLogPath = dir + "/events.json"
+	cfg.ClickLogPath = dir + "/clicks.json"
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	source := config.New(cfg)
+	registry, err := domains.New(cfg.BaseURL, cfg.PathPrefix, cfg.Domains)
+	require.NoError(t, err)
+	return &Handler{
+		Store:     &storage.MemoryStore{Config: source},
+		Config:    source,
+		Validator: validate.New(cfg.Blocklist),
+		Domains:   registry,
+	}
+}
+
 func TestShorten(t *testing.T) {
-	config.SetDefaults()
-	StoreHandler = &storage.MemoryStore{}
+	t.Parallel()
+	h := newTestHandler(t)
 	tests := []struct {
 		name   string
 		body   string
@@ -33,7 +69,7 @@ func TestShorten(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
 			rec := httptest.NewRecorder()
-			Shorten(rec, request)
+			h.Shorten(rec, request)
 			resp := rec.Result()
 			defer resp.Body.Close()
 
@@ -46,73 +82,141 @@ func TestShorten(t *testing.T) {
 	}
 }
 
+func TestShortenQuota(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	key := storage.APIKey{ID: "ci", Hash: "ci-hash", Scopes: []string{auth.ScopeShorten}, Quota: 2}
+	require.NoError(t, h.Store.CreateAPIKey(context.TODO(), key))
+
+	shorten := func(body string) int {
+		key, err := h.Store.FindAPIKey(context.TODO(), "ci-hash")
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ShortenAPI(rec, request.WithContext(auth.WithAPIKey(request.Context(), key)))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusCreated, shorten(`{"url": "https://practicum.yandex.ru", "code": "yandex"}`))
+	assert.Equal(t, http.StatusConflict, shorten(`{"url": "https://go.dev", "code": "yandex"}`))
+	assert.Equal(t, http.StatusCreated, shorten(`{"url": "https://go.dev"}`))
+	assert.Equal(t, http.StatusTooManyRequests, shorten(`{"url": "https://pkg.go.dev"}`))
+
+	key, err := h.Store.FindAPIKey(context.TODO(), "ci-hash")
+	require.NoError(t, err)
+	assert.Equal(t, 2, key.Used)
+}
+
+func TestShortenQuotaConcurrent(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	key := storage.APIKey{ID: "ci", Hash: "ci-hash", Scopes: []string{auth.ScopeShorten}, Quota: 2}
+	require.NoError(t, h.Store.CreateAPIKey(context.TODO(), key))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"url": "https://go.dev/%d"}`, i)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			h.ShortenAPI(rec, request.WithContext(auth.WithAPIKey(request.Context(), key)))
+			codes[i] = rec.Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		if code == http.StatusCreated {
+			created++
+		}
+	}
+	assert.Equal(t, 2, created)
+	key, err := h.Store.FindAPIKey(context.TODO(), "ci-hash")
+	require.NoError(t, err)
+	assert.Equal(t, 2, key.Used)
+}
+
 func TestShortenAPI(t *testing.T) {
-	config.SetDefaults()
-	StoreHandler = &storage.MemoryStore{}
+	t.Parallel()
+	h := newTestHandler(t)
 
 	tests := []struct {
 		name   string
 		body   string
-		want   apiResponse
+		want   string
+		kind   problem.Kind
 		status int
 	}{
 		{
 			"with valid URL",
 			`{"url": "https://practicum.yandex.ru"}`,
-			apiResponse{Result: "http://localhost:8080/.{8}$"},
+			"http://localhost:8080/.{8}$",
+			"",
 			http.StatusCreated,
 		},
 		{
 			"with invalid URL",
 			`{"url": "https//practicum.yandex.ru"}`,
-			apiResponse{Error: "Invalid URL: .*"},
+			"Invalid URL: .*",
+			problem.Validation,
 			http.StatusBadRequest,
 		},
 		{
 			"with incorrect JSON key",
 			`{"uri": "https://practicum.yandex.ru"}`,
-			apiResponse{Error: "Invalid URL: .*"},
+			"Invalid URL: .*",
+			problem.Validation,
 			http.StatusBadRequest,
 		},
 		{
 			"with string request",
 			"https://practicum.yandex.ru",
-			apiResponse{Error: "Invalid request format."},
+			"Invalid request format.",
+			problem.Validation,
 			http.StatusBadRequest,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
 			rec := httptest.NewRecorder()
-			ShortenAPI(rec, request)
+			h.ShortenAPI(rec, request)
 			resp := rec.Result()
 			defer resp.Body.Close()
 
-			var resBody apiResponse
-			err := json.NewDecoder(resp.Body).Decode(&resBody)
-			require.NoError(t, err)
-
 			assert.Equal(t, tt.status, resp.StatusCode)
-			if tt.want.Error != "" {
-				assert.Regexp(t, tt.want.Error, resBody.Error)
-			}
-			if tt.want.Result != "" {
-				assert.Regexp(t, tt.want.Result, resBody.Result)
+			if tt.kind == "" {
+				var resBody apiResponse
+				err := json.NewDecoder(resp.Body).Decode(&resBody)
+				require.NoError(t, err)
+				assert.Regexp(t, tt.want, resBody.Result)
+				return
 			}
+
+			var resBody problem.Problem
+			err := json.NewDecoder(resp.Body).Decode(&resBody)
+			require.NoError(t, err)
+			assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, tt.kind, resBody.Kind)
+			assert.Equal(t, tt.status, resBody.Status)
+			assert.Regexp(t, tt.want, resBody.Detail)
 		})
 	}
 }
 
 func TestShortenAPIBatch(t *testing.T) {
-	config.SetDefaults()
-	StoreHandler = &storage.MemoryStore{}
+	t.Parallel()
+	h := newTestHandler(t)
 
 	tests := []struct {
 		name     string
 		body     string
 		response []storage.URLStore
-		error    apiResponse
+		error    string
 		status   int
 	}{
 		{
@@ -131,7 +235,7 @@ func TestShortenAPIBatch(t *testing.T) {
 				{CorrelationID: "30d53d47-6d08-41ce-992f-097b0f01479b", ShortURL: "http://localhost:8080/.{8}$"},
 				{CorrelationID: "c65f7a7b-770d-4a59-97d2-946bcdfa2589", ShortURL: "http://localhost:8080/.{8}$"},
 			},
-			apiResponse{},
+			"",
 			http.StatusCreated,
 		},
 		{
@@ -143,29 +247,30 @@ func TestShortenAPIBatch(t *testing.T) {
 					}
 				]`,
 			[]storage.URLStore{},
-			apiResponse{Error: "Invalid URL: .*"},
+			"Invalid URL: .*",
 			http.StatusBadRequest,
 		},
 		{
 			"with incorrect JSON key",
 			`[{"uri": "https://practicum.yandex.ru"}]`,
 			[]storage.URLStore{},
-			apiResponse{Error: "Invalid URL: .*"},
+			"Invalid URL: .*",
 			http.StatusBadRequest,
 		},
 		{
 			"with string request",
 			"https://practicum.yandex.ru",
 			[]storage.URLStore{},
-			apiResponse{Error: "Invalid request format."},
+			"Invalid request format.",
 			http.StatusBadRequest,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
 			rec := httptest.NewRecorder()
-			ShortenAPIBatch(rec, request)
+			h.ShortenAPIBatch(rec, request)
 			resp := rec.Result()
 			defer resp.Body.Close()
 
@@ -180,34 +285,34 @@ func TestShortenAPIBatch(t *testing.T) {
 					assert.Regexp(t, res.ShortURL, resBody[i].ShortURL)
 				}
 			case http.StatusBadRequest:
-				var resBody apiResponse
+				var resBody problem.Problem
 				err := json.NewDecoder(resp.Body).Decode(&resBody)
 				require.NoError(t, err)
-				assert.Regexp(t, tt.error.Error, resBody.Error)
+				assert.Equal(t, problem.Validation, resBody.Kind)
+				assert.Regexp(t, tt.error, resBody.Detail)
 			}
 		})
 	}
 }
 
 func TestExpand(t *testing.T) {
-	config.SetDefaults()
-	StoreHandler = &storage.MemoryStore{}
-	result, _ := StoreHandler.Save(context.TODO(), "https://practicum.yandex.ru")
-	urlParts := strings.Split(result, "/")
+	t.Parallel()
+	h := newTestHandler(t)
+	result, _ := h.Store.Save(context.TODO(), storage.URLStore{OriginalURL: "https://practicum.yandex.ru"})
 
 	tests := []struct {
 		name   string
 		id     string
 		status int
 	}{
-		{"with stored ID", urlParts[len(urlParts)-1], http.StatusTemporaryRedirect},
+		{"with stored ID", result.ShortURL, http.StatusTemporaryRedirect},
 		{"with random ID", util.RandomString(8), http.StatusNotFound},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(http.MethodGet, "/"+tt.id, nil)
 			rec := httptest.NewRecorder()
-			Expand(rec, request)
+			h.Expand(rec, request)
 			resp := rec.Result()
 			defer resp.Body.Close()
 
@@ -217,3 +322,864 @@ func TestExpand(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandPassthrough(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+
+	tests := []struct {
+		name        string
+		destination string
+		passthrough string
+		query       string
+		want        string
+	}{
+		{"ignore", "https://go.dev/doc?a=1", "", "?utm_source=x", "https://go.dev/doc?a=1"},
+		{"merge", "https://go.dev/doc?utm_source=site", "merge", "?utm_source=x&utm_medium=mail",
+			"https://go.dev/doc?utm_source=site&utm_medium=mail"},
+		{"override", "https://go.dev/ref?utm_source=site", "override", "?utm_source=x",
+			"https://go.dev/ref?utm_source=x"},
+		{"template", "https://go.dev/doc?src={query.ref}", "", "?ref=a%20b", "https://go.dev/doc?src=a+b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"url": %q, "passthrough": %q}`, tt.destination, tt.passthrough)
+			rec := httptest.NewRecorder()
+			h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body)))
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			var created apiResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+			path := strings.TrimPrefix(created.Result, h.Domains.Default().BaseURL)
+			rec = httptest.NewRecorder()
+			h.Expand(rec, httptest.NewRequest(http.MethodGet, path+tt.query, nil))
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			assert.Equal(t, tt.want, rec.Header().Get("Location"))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "https://go.dev", "passthrough": "append"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPrefixLinks(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	exact, err := h.Store.Save(context.TODO(), storage.URLStore{OriginalURL: "https://go.dev/doc"})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Post("/api/shorten", h.ShortenAPI)
+	r.Get("/api/qr/{id}", h.QRCode)
+	r.Route("/{id}", func(r chi.Router) {
+		r.Get("/", h.Expand)
+		r.Get("/*", h.Expand)
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		location string
+	}{
+		{"create prefix link", http.MethodPost, "/api/shorten",
+			`{"url": "https://docs.example.com/?lang=en", "code": "docs", "prefix": true, "passthrough": "merge"}`,
+			http.StatusCreated, ""},
+		{"create with taken code", http.MethodPost, "/api/shorten",
+			`{"url": "https://docs.example.com/other", "code": "docs"}`, http.StatusConflict, ""},
+		{"create with invalid code", http.MethodPost, "/api/shorten",
+			`{"url": "https://docs.example.com", "code": "docs/api"}`, http.StatusBadRequest, ""},
+		{"create with reserved code", http.MethodPost, "/api/shorten",
+			`{"url": "https://docs.example.com", "code": "healthz"}`, http.StatusBadRequest, ""},
+		{"prefix link itself", http.MethodGet, "/docs", "", http.StatusTemporaryRedirect,
+			"https://docs.example.com/?lang=en"},
+		{"deep path", http.MethodGet, "/docs/api/v2?utm_source=x", "", http.StatusTemporaryRedirect,
+			"https://docs.example.com/api/v2?lang=en&utm_source=x"},
+		{"deep path with trailing slash", http.MethodGet, "/docs/api/", "", http.StatusTemporaryRedirect,
+			"https://docs.example.com/api?lang=en"},
+		{"qr code of prefix link", http.MethodGet, "/api/qr/docs", "", http.StatusOK, ""},
+		{"qr path of prefix link", http.MethodGet, "/docs/qr", "", http.StatusTemporaryRedirect,
+			"https://docs.example.com/qr?lang=en"},
+		{"deep path of exact code", http.MethodGet, "/" + exact.ShortURL + "/api", "", http.StatusNotFound, ""},
+		{"exact code", http.MethodGet, "/" + exact.ShortURL, "", http.StatusTemporaryRedirect, "https://go.dev/doc"},
+		{"deep path of unknown code", http.MethodGet, "/unknown1/api", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.Equal(t, tt.location, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.Blocklist = []string{"evil.example"}
+	})
+	path := t.TempDir() + "/countries.csv"
+	require.NoError(t, os.WriteFile(path, []byte("198.51.100.0/24,DE\n"), 0600))
+	var err error
+	h.GeoIP, err = geoip.NewLocator(path)
+	require.NoError(t, err)
+
+	result, err := h.Store.Save(context.TODO(), storage.URLStore{OriginalURL: "https://example.com", Passthrough: "merge"})
+	require.NoError(t, err)
+	id := result.ShortURL
+
+	r := chi.NewRouter()
+	r.Get("/api/admin/urls/{id}/rules", h.AdminGetRules)
+	r.Put("/api/admin/urls/{id}/rules", h.AdminSetRules)
+	r.Get("/{id}", h.Expand)
+
+	admin := []struct {
+		name   string
+		method string
+		body   string
+		want   string
+		status int
+	}{
+		{"no rules yet", http.MethodGet, "", "[]", http.StatusOK},
+		{"invalid rule", http.MethodPut, `[{"devices": ["watch"], "destination": "https://example.com/watch"}]`,
+			"unknown device", http.StatusBadRequest},
+		{"blocked destination", http.MethodPut, `[{"split": [{"destination": "https://evil.example", "weight": 1}]}]`,
+			"URL is blocked", http.StatusBadRequest},
+		{"set rules", http.MethodPut, `[
+			{"devices": ["ios"], "destination": "https://apps.apple.com/app/id1"},
+			{"countries": ["de"], "languages": ["de"], "destination": "https://example.de"},
+			{"until": "2000-01-01T00:00:00Z", "destination": "https://example.com/expired"}
+		]`, `"destination":"https://example.de"`, http.StatusOK},
+		{"read rules", http.MethodGet, "", `"devices":["ios"]`, http.StatusOK},
+	}
+	for _, tt := range admin {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/admin/urls/"+id+"/rules", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tt.want)
+		})
+	}
+
+	redirects := []struct {
+		name       string
+		userAgent  string
+		language   string
+		remoteAddr string
+		want       string
+	}{
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", "", "192.0.2.1:1234",
+			"https://apps.apple.com/app/id1?utm_source=x"},
+		{"german in germany", "Mozilla/5.0 (X11; Linux x86_64)", "de-DE,en;q=0.5", "198.51.100.7:1234",
+			"https://example.de?utm_source=x"},
+		{"german elsewhere", "Mozilla/5.0 (X11; Linux x86_64)", "de-DE", "192.0.2.1:1234",
+			"https://example.com?utm_source=x"},
+	}
+	for _, tt := range redirects {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/"+id+"?utm_source=x", nil)
+			request.Header.Set("User-Agent", tt.userAgent)
+			request.Header.Set("Accept-Language", tt.language)
+			request.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, request)
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			assert.Equal(t, tt.want, rec.Header().Get("Location"))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/admin/urls/"+id+"/rules", strings.NewReader("[]")))
+	assert.Equal(t, "[]\n", rec.Body.String())
+}
+
+func TestSplitLinks(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	key := storage.APIKey{ID: "growth", Scopes: []string{auth.ScopeShorten, auth.ScopeStats}}
+	require.NoError(t, h.Store.CreateAPIKey(context.TODO(), key))
+	ctx := auth.WithAPIKey(context.Background(), key)
+
+	r := chi.NewRouter()
+	r.Get("/api/admin/urls/{id}/split", h.AdminGetSplit)
+	r.Put("/api/admin/urls/{id}/split", h.AdminSetSplit)
+	r.Get("/{id}", h.Expand)
+
+	body := `{"url": "https://example.com", "code": "launch", "split": [
+		{"destination": "https://example.com/a", "weight": 3},
+		{"destination": "https://example.com/b", "weight": 1}
+	]}`
+	rec := httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body)).WithContext(ctx))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.org",
+		"split": [{"destination": "https://example.org/a", "weight": 1}, {"destination": "https://example.org/a", "weight": 1}]}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "duplicate split destination")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/launch", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "visitor_id", cookies[0].Name)
+	assert.Equal(t, "/", cookies[0].Path)
+	first := rec.Header().Get("Location")
+	assert.Contains(t, []string{"https://example.com/a", "https://example.com/b"}, first)
+
+	for range 5 {
+		request := httptest.NewRequest(http.MethodGet, "/launch", nil)
+		request.AddCookie(cookies[0])
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, request)
+		assert.Equal(t, first, rec.Header().Get("Location"))
+		assert.Empty(t, rec.Result().Cookies())
+	}
+	for i := range 40 {
+		request := httptest.NewRequest(http.MethodGet, "/launch", nil)
+		request.AddCookie(&http.Cookie{Name: "visitor_id", Value: fmt.Sprintf("visitor-%d", i)})
+		r.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	rec = httptest.NewRecorder()
+	h.UserURLs(rec, httptest.NewRequest(http.MethodGet, "/api/user/urls", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var listed []userURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	require.Len(t, listed[0].Variants, 2)
+	assert.Equal(t, "https://example.com/a", listed[0].Variants[0].Destination)
+	assert.Equal(t, 3, listed[0].Variants[0].Weight)
+	assert.Equal(t, int64(46), listed[0].Variants[0].Clicks+listed[0].Variants[1].Clicks)
+	assert.Positive(t, listed[0].Variants[1].Clicks)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/admin/urls/launch/split",
+		strings.NewReader(`[{"destination": "https://example.com/b", "weight": 1}]`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var variants []variantStat
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &variants))
+	require.Len(t, variants, 1)
+	assert.Equal(t, listed[0].Variants[1].Clicks, variants[0].Clicks)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/admin/urls/launch/split", strings.NewReader("[]")))
+	assert.Equal(t, "[]\n", rec.Body.String())
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/launch", nil))
+	assert.Equal(t, "https://example.com", rec.Header().Get("Location"))
+	assert.Empty(t, rec.Result().Cookies())
+}
+
+func TestSplitCookiePath(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.PathPrefix = "/s"
+	})
+	_, err := h.Store.Save(context.TODO(), storage.URLStore{ShortURL: "launch", OriginalURL: "https://example.com",
+		Split: []rules.Variant{{Destination: "https://example.com/a", Weight: 1}}})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.Expand(rec, httptest.NewRequest(http.MethodGet, "/launch", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "/s", cookies[0].Path)
+}
+
+func TestProtectedLinks(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Get("/{id}", h.Expand)
+	r.Post("/{id}", h.Expand)
+
+	rec := httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "https://docs.example.com/secret", "code": "secret", "password": "hunter2",
+			"passthrough": "merge"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	item, err := h.Store.GetURL(context.TODO(), "", "secret")
+	require.NoError(t, err)
+	assert.NotContains(t, item.PasswordHash, "hunter2")
+
+	rec = httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "https://docs.example.com/long", "password": "`+strings.Repeat("x", 73)+`"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	tests := []struct {
+		name     string
+		method   string
+		password string
+		status   int
+		location string
+		body     string
+	}{
+		{"form", http.MethodGet, "", http.StatusOK, "", `<form method="post">`},
+		{"wrong password", http.MethodPost, "hunter3", http.StatusForbidden, "", "Wrong password."},
+		{"no password", http.MethodPost, "", http.StatusForbidden, "", "Wrong password."},
+		{"right password", http.MethodPost, "hunter2", http.StatusSeeOther, "https://docs.example.com/secret?page=2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"password": {tt.password}}
+			request := httptest.NewRequest(tt.method, "/secret?page=2", strings.NewReader(form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, request)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.location, rec.Header().Get("Location"))
+			assert.Contains(t, rec.Body.String(), tt.body)
+			if tt.body != "" {
+				assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+				assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestMaxClicks(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Get("/{id}", h.Expand)
+	r.Patch("/api/admin/urls/{id}", h.AdminUpdateURL)
+
+	rec := httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "https://example.com/invite", "code": "invite", "max_clicks": 5}`)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	statuses := make(chan int, 20)
+	var wg sync.WaitGroup
+	for range cap(statuses) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/invite", nil))
+			statuses <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusTemporaryRedirect: 5, http.StatusGone: 15}, counts)
+
+	item, err := h.Store.GetURL(context.TODO(), "", "invite")
+	require.NoError(t, err)
+	assert.True(t, item.Disabled)
+	assert.Equal(t, 5, item.Clicks)
+	events, err := h.Store.ListEvents(context.TODO(), storage.EventFilter{ShortURL: "invite"})
+	require.NoError(t, err)
+	require.NotEmpty(t, events.Events)
+	assert.Equal(t, storage.EventExpired, events.Events[0].Type)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/api/admin/urls/invite",
+		strings.NewReader(`{"disabled": false, "clicks": 4}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"clicks":4`)
+	for _, want := range []int{http.StatusTemporaryRedirect, http.StatusGone} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/invite", nil))
+		assert.Equal(t, want, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/api/admin/urls/invite",
+		strings.NewReader(`{"max_clicks": -1}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestLinkMetadata(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	key := storage.APIKey{ID: "docs-team", Scopes: []string{auth.ScopeShorten, auth.ScopeStats}}
+	require.NoError(t, h.Store.CreateAPIKey(context.TODO(), key))
+	ctx := auth.WithAPIKey(context.Background(), key)
+
+	r := chi.NewRouter()
+	r.Patch("/api/admin/urls/{id}", h.AdminUpdateURL)
+
+	for _, body := range []string{
+		`{"url": "https://docs.example.com/a", "code": "a", "title": "Guide", "description": "How to start.",
+			"tags": [" Docs", "onboarding", "docs"]}`,
+		`{"url": "https://docs.example.com/b", "code": "b", "tags": ["onboarding"]}`,
+	} {
+		rec := httptest.NewRecorder()
+		h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body)).WithContext(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	for _, body := range []string{
+		`{"url": "https://docs.example.com/c", "tags": ["two words"]}`,
+		`{"url": "https://docs.example.com/c", "title": "` + strings.Repeat("x", maxTitleLength+1) + `"}`,
+	} {
+		rec := httptest.NewRecorder()
+		h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body)).WithContext(ctx))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+
+	list := func(query string) []userURL {
+		rec := httptest.NewRecorder()
+		h.UserURLs(rec, httptest.NewRequest(http.MethodGet, "/api/user/urls"+query, nil).WithContext(ctx))
+		if rec.Code == http.StatusNoContent {
+			return nil
+		}
+		require.Equal(t, http.StatusOK, rec.Code)
+		var listed []userURL
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+		return listed
+	}
+	listed := list("")
+	require.Len(t, listed, 2)
+	assert.Equal(t, "Guide", listed[0].Title)
+	assert.Equal(t, "How to start.", listed[0].Description)
+	assert.Equal(t, []string{"docs", "onboarding"}, listed[0].Tags)
+	assert.False(t, listed[0].CreatedAt.IsZero())
+	assert.Len(t, list("?tag=Onboarding"), 2)
+	require.Len(t, list("?tag=docs"), 1)
+	assert.Empty(t, list("?tag=missing"))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/api/admin/urls/b",
+		strings.NewReader(`{"title": "Reference", "tags": ["DOCS"]}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"tags":["docs"]`)
+	assert.Len(t, list("?tag=docs"), 2)
+	assert.Len(t, list("?tag=onboarding"), 1)
+
+	events, err := h.Store.ListEvents(context.TODO(), storage.EventFilter{ShortURL: "b"})
+	require.NoError(t, err)
+	var fields []string
+	for _, event := range events.Events {
+		fields = append(fields, event.Field)
+	}
+	assert.Contains(t, fields, "title")
+	assert.Contains(t, fields, "tags")
+}
+
+func TestUserURLsPages(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	key := storage.APIKey{ID: "paging", Scopes: []string{auth.ScopeShorten, auth.ScopeStats}}
+	require.NoError(t, h.Store.CreateAPIKey(context.TODO(), key))
+	ctx := auth.WithAPIKey(context.Background(), key)
+	r := chi.NewRouter()
+	r.Get("/{id}", h.Expand)
+
+	for i := range 5 {
+		body := fmt.Sprintf(`{"url": "https://example.com/%d", "code": "page%d", "title": "Page %d"}`, i, i, i)
+		rec := httptest.NewRecorder()
+		h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body)).WithContext(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	for range 3 {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page2", nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page4", nil))
+
+	list := func(query string) ([]userURL, string) {
+		rec := httptest.NewRecorder()
+		h.UserURLs(rec, httptest.NewRequest(http.MethodGet, "/api/user/urls"+query, nil).WithContext(ctx))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var listed []userURL
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+		return listed, rec.Header().Get("Link")
+	}
+
+	listed, link := list("?sort=-clicks&limit=2")
+	require.Len(t, listed, 2)
+	assert.Equal(t, h.shortURL(storage.URLStore{ShortURL: "page2"}), listed[0].ShortURL)
+	assert.Equal(t, int64(3), listed[0].Clicks)
+	assert.Equal(t, int64(1), listed[1].Clicks)
+	next, ok := strings.CutPrefix(link, "<")
+	require.True(t, ok, link)
+	next, ok = strings.CutSuffix(next, `>; rel="next"`)
+	require.True(t, ok, link)
+	params, err := url.ParseQuery(strings.TrimPrefix(next, "?"))
+	require.NoError(t, err)
+	assert.Equal(t, "-clicks", params.Get("sort"))
+
+	var seen []string
+	for _, item := range listed {
+		seen = append(seen, item.ShortURL)
+	}
+	for next != "" {
+		listed, link = list(next)
+		for _, item := range listed {
+			seen = append(seen, item.ShortURL)
+		}
+		next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	}
+	assert.Len(t, seen, 5)
+
+	listed, link = list("?q=page+3")
+	require.Len(t, listed, 1)
+	assert.Equal(t, "Page 3", listed[0].Title)
+	assert.Empty(t, link)
+
+	for _, query := range []string{"?sort=title", "?limit=0", "?cursor=bogus"} {
+		rec := httptest.NewRecorder()
+		h.UserURLs(rec, httptest.NewRequest(http.MethodGet, "/api/user/urls"+query, nil).WithContext(ctx))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func TestFetchPreview(t *testing.T) {
+	t.Parallel()
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>Release notes</title>
+			<meta property="og:description" content="What changed in 2.0."></head></html>`))
+	}))
+	defer page.Close()
+
+	h := newTestHandler(t, func(cfg *config.Config) { cfg.FetchPreviews = true })
+	h.Preview = preview.NewFetcher()
+	h.Preview.AllowPrivate = true
+
+	rec := httptest.NewRecorder()
+	h.ShortenAPI(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "`+page.URL+`/notes", "code": "notes", "title": "Our title"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = httptest.NewRecorder()
+	h.Shorten(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(page.URL+"/plain")))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	plain := strings.TrimPrefix(rec.Body.String(), h.Config.Get().BaseURL+"/")
+
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		item, err := h.Store.GetURL(context.TODO(), "", plain)
+		require.NoError(c, err)
+		assert.Equal(c, "Release notes", item.Title)
+		assert.Equal(c, "What changed in 2.0.", item.Description)
+
+		item, err = h.Store.GetURL(context.TODO(), "", "notes")
+		require.NoError(c, err)
+		assert.Equal(c, "Our title", item.Title)
+		assert.Equal(c, "What changed in 2.0.", item.Description)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDomains(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.Domains = []string{"https://go.example.com"}
+	})
+
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru"))
+	request.Host = "go.example.com"
+	rec := httptest.NewRecorder()
+	h.Shorten(rec, request)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Regexp(t, "^https://go.example.com/.{8}$", rec.Body.String())
+	id := rec.Body.String()[strings.LastIndex(rec.Body.String(), "/")+1:]
+
+	tests := []struct {
+		name   string
+		host   string
+		status int
+	}{
+		{"on its domain", "go.example.com", http.StatusTemporaryRedirect},
+		{"on its domain with port", "GO.example.com:443", http.StatusTemporaryRedirect},
+		{"on the default domain", "localhost:8080", http.StatusNotFound},
+		{"on an unknown host", "other.example.com", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+			request.Host = tt.host
+			rec := httptest.NewRecorder()
+			h.Expand(rec, request)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	result, err := h.Store.Save(context.TODO(), storage.URLStore{OriginalURL: "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/api/qr/{id}", h.QRCode)
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := get("/api/qr/"+result.ShortURL, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	tests := []struct {
+		name        string
+		path        string
+		etag        string
+		status      int
+		contentType string
+	}{
+		{"cached", "/api/qr/" + result.ShortURL, etag, http.StatusNotModified, ""},
+		{"other options", "/api/qr/" + result.ShortURL + "?size=128", etag, http.StatusOK, "image/png"},
+		{"svg", "/api/qr/" + result.ShortURL + "?format=svg&level=H&margin=2", "", http.StatusOK, "image/svg+xml"},
+		{"invalid level", "/api/qr/" + result.ShortURL + "?level=Z", "", http.StatusBadRequest, ""},
+		{"unknown ID", "/api/qr/unknown1", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.path, tt.etag)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		path   string
+		want   string
+		status int
+	}{
+		{"with writable storage", t.TempDir() + "/urls.json", storage.HealthUp, http.StatusOK},
+		{"with missing directory", t.TempDir() + "/missing/urls.json", storage.HealthDown, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, func(cfg *config.Config) { cfg.FileStoragePath = tt.path })
+			request := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			rec := httptest.NewRecorder()
+			h.Readiness(rec, request)
+			resp := rec.Result()
+			defer resp.Body.Close()
+			assert.NotContains(t, rec.Body.String(), tt.path)
+
+			var resBody healthResponse
+			err := json.NewDecoder(resp.Body).Decode(&resBody)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.want, resBody.Status)
+			require.Len(t, resBody.Components, 1)
+			assert.Equal(t, "file_storage", resBody.Components[0].Name)
+		})
+	}
+}
+
+func TestAdminURLs(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	result, err := h.Store.Save(context.TODO(),
+		storage.URLStore{OriginalURL: "https://practicum.yandex.ru", UserID: "owner"})
+	require.NoError(t, err)
+	id := result.ShortURL
+
+	r := chi.NewRouter()
+	r.Get("/api/admin/urls", h.AdminFindURLs)
+	r.Get("/api/admin/urls/{id}", h.AdminGetURL)
+	r.Patch("/api/admin/urls/{id}", h.AdminUpdateURL)
+	r.Delete("/api/admin/urls/{id}", h.AdminDeleteURL)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   string
+		status int
+	}{
+		{"find by original URL", http.MethodGet, "/api/admin/urls?original_url=https://practicum.yandex.ru",
+			"", `"id":"` + id + `"`, http.StatusOK},
+		{"find without filter", http.MethodGet, "/api/admin/urls", "", "validation", http.StatusBadRequest},
+		{"get by code", http.MethodGet, "/api/admin/urls/" + id, "", `"user_id":"owner"`, http.StatusOK},
+		{"get unknown code", http.MethodGet, "/api/admin/urls/unknown1", "", "not_found", http.StatusNotFound},
+		{"edit destination", http.MethodPatch, "/api/admin/urls/" + id,
+			`{"original_url": "https://ya.ru"}`, `"original_url":"https://ya.ru"`, http.StatusOK},
+		{"edit to invalid URL", http.MethodPatch, "/api/admin/urls/" + id,
+			`{"original_url": "ya.ru"}`, "Invalid URL", http.StatusBadRequest},
+		{"empty update", http.MethodPatch, "/api/admin/urls/" + id, `{}`, "Nothing to update", http.StatusBadRequest},
+		{"set passthrough", http.MethodPatch, "/api/admin/urls/" + id,
+			`{"passthrough": "merge"}`, `"passthrough":"merge"`, http.StatusOK},
+		{"set prefix", http.MethodPatch, "/api/admin/urls/" + id, `{"prefix": true}`, `"is_prefix":true`, http.StatusOK},
+		{"set invalid passthrough", http.MethodPatch, "/api/admin/urls/" + id,
+			`{"passthrough": "append"}`, "Passthrough must be", http.StatusBadRequest},
+		{"transfer and disable", http.MethodPatch, "/api/admin/urls/" + id,
+			`{"user_id": "new-owner", "disabled": true}`, `"user_id":"new-owner"`, http.StatusOK},
+		{"hard delete", http.MethodDelete, "/api/admin/urls/" + id, "", "", http.StatusNoContent},
+		{"hard delete again", http.MethodDelete, "/api/admin/urls/" + id, "", "not_found", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, request)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tt.want)
+		})
+	}
+}
+
+func TestBaseURLChange(t *testing.T) {
+	t.Parallel()
+	before := newTestHandler(t)
+	result, err := before.Store.Save(context.TODO(), storage.URLStore{OriginalURL: "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+
+	after := newTestHandler(t, func(cfg *config.Config) {
+		cfg.BaseURL = "https://sho.rt"
+		cfg.FileStoragePath = before.Config.Get().FileStoragePath
+	})
+	require.NoError(t, after.Store.Initialize(context.TODO()))
+
+	r := chi.NewRouter()
+	r.Get("/api/admin/urls/{id}", after.AdminGetURL)
+	request := httptest.NewRequest(http.MethodGet, "/api/admin/urls/"+result.ShortURL, nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"short_url":"https://sho.rt/`+result.ShortURL+`"`)
+}
+
+func TestExpandDisabled(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t)
+	result, err := h.Store.Save(context.TODO(), storage.URLStore{OriginalURL: "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+	id := result.ShortURL
+
+	disabled := true
+	_, err = h.Store.UpdateURL(context.TODO(), "", id, storage.URLUpdate{Disabled: &disabled})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	rec := httptest.NewRecorder()
+	h.Expand(rec, request)
+	assert.Equal(t, http.StatusGone, rec.Code)
+}
+
+func TestAdminEvents(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.Domains = []string{"https://go.example.com"}
+	})
+
+	shorten := func(body string) string {
+		rec := httptest.NewRecorder()
+		h.Shorten(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		return rec.Body.String()[strings.LastIndex(rec.Body.String(), "/")+1:]
+	}
+	first := shorten("https://practicum.yandex.ru")
+	start := time.Now().UTC()
+	second := shorten("https://ya.ru")
+	other := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://go.dev"))
+	other.Host = "go.example.com"
+	h.Shorten(httptest.NewRecorder(), other)
+
+	r := chi.NewRouter()
+	r.Patch("/api/admin/urls/{id}", h.AdminUpdateURL)
+	r.Get("/api/admin/events", h.AdminEvents)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/api/admin/urls/"+second, strings.NewReader(`{"disabled": true}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	tests := []struct {
+		name   string
+		query  string
+		want   []storage.EventType
+		status int
+	}{
+		{"all events", "", []storage.EventType{storage.EventDisabled, storage.EventCreated, storage.EventCreated}, http.StatusOK},
+		{"by link", "?short_url=" + first, []storage.EventType{storage.EventCreated}, http.StatusOK},
+		{"by time range", "?from=" + start.Format(time.RFC3339Nano),
+			[]storage.EventType{storage.EventDisabled, storage.EventCreated}, http.StatusOK},
+		{"by domain", "?domain=go.example.com", []storage.EventType{storage.EventCreated}, http.StatusOK},
+		{"by unknown domain", "?domain=nowhere.example.com", nil, http.StatusNotFound},
+		{"by unknown user", "?user_id=nobody", []storage.EventType{}, http.StatusOK},
+		{"with limit", "?limit=1", []storage.EventType{storage.EventDisabled}, http.StatusOK},
+		{"with invalid time", "?from=yesterday", nil, http.StatusBadRequest},
+		{"with invalid cursor", "?cursor=bogus", nil, http.StatusBadRequest},
+		{"with invalid limit", "?limit=0", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/events"+tt.query, nil))
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var events []storage.Event
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&events))
+			types := make([]storage.EventType, 0, len(events))
+			for _, event := range events {
+				types = append(types, event.Type)
+				assert.Equal(t, "anonymous", event.Actor)
+			}
+			assert.Equal(t, tt.want, types)
+		})
+	}
+
+	var ids []string
+	for next := "?limit=1"; next != ""; {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/events"+next, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var events []storage.Event
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&events))
+		require.Len(t, events, 1)
+		ids = append(ids, events[0].ShortURL+"/"+string(events[0].Type))
+		next = strings.TrimSuffix(strings.TrimPrefix(rec.Header().Get("Link"), "<"), `>; rel="next"`)
+	}
+	assert.Equal(t, []string{second + "/disabled", second + "/created", first + "/created"}, ids)
+}
+
+func TestStorageProblem(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		err    error
+		kind   problem.Kind
+		status int
+	}{
+		{"typed error", problem.New(problem.Validation, "bad"), problem.Validation, http.StatusBadRequest},
+		{"conflict", storage.ConflictError{URL: storage.URLStore{ShortURL: "abc"}}, problem.Conflict, http.StatusConflict},
+		{"deleted", fmt.Errorf("get: %w", storage.ErrURLDeleted), problem.Gone, http.StatusGone},
+		{"quota", storage.ErrQuotaExceeded, problem.RateLimited, http.StatusTooManyRequests},
+		{"unknown", errors.New("boom"), problem.Internal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := storageProblem(tt.err)
+			assert.Equal(t, tt.kind, e.Kind)
+			assert.Equal(t, tt.status, e.Status())
+		})
+	}
+}
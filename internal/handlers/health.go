@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/util"
+)
+
+const readinessTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status     string                    `json:"status"`
+	DurationMS float64                   `json:"duration_ms"`
+	Components []storage.ComponentHealth `json:"components,omitempty"`
+}
+
+func (h *Handler) Liveness(w http.ResponseWriter, _ *http.Request) {
+	util.JSONResponse(w, healthResponse{Status: storage.HealthUp}, http.StatusOK)
+}
+
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	response := healthResponse{Status: storage.HealthUp}
+	response.Components = h.Store.CheckHealth(ctx)
+	response.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+
+	status := http.StatusOK
+	for _, component := range response.Components {
+		if component.Status != storage.HealthUp {
+			logger.FromContext(r.Context()).Warnw("readiness check failed",
+				"component", component.Name, "error", component.Error)
+			response.Status = storage.HealthDown
+			status = http.StatusServiceUnavailable
+		}
+	}
+	util.JSONResponse(w, response, status)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/preview"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+)
+
+const (
+	maxTitleLength       = 256
+	maxDescriptionLength = 1024
+	maxTags              = 20
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_.:-]{1,64}$`)
+
+func validText(field, value string, maxLength int) error {
+	if utf8.RuneCountInString(value) > maxLength {
+		return problem.New(problem.Validation, "%s must be at most %d characters.", field, maxLength)
+	}
+	return nil
+}
+
+func validMetadata(title, description string, tags []string) ([]string, error) {
+	if err := validText("Title", title, maxTitleLength); err != nil {
+		return nil, err
+	}
+	if err := validText("Description", description, maxDescriptionLength); err != nil {
+		return nil, err
+	}
+	return normalizeTags(tags)
+}
+
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, problem.New(problem.Validation,
+				"Tags must be 1 to 64 letters, digits, dots, colons, dashes or underscores: %q", tag)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, problem.New(problem.Validation, "A link can have at most %d tags.", maxTags)
+	}
+	return normalized, nil
+}
+
+// fetchPreview queues new links without a title or description for the
+// preview workers.
+func (h *Handler) fetchPreview(req *http.Request, items ...storage.URLStore) {
+	if h.Preview == nil || !h.Config.Get().FetchPreviews {
+		return
+	}
+	ctx := context.WithoutCancel(req.Context())
+	for _, item := range items {
+		if item.Title != "" && item.Description != "" {
+			continue
+		}
+		err := h.Preview.Queue(ctx, item.OriginalURL, func(metadata preview.Metadata, err error) {
+			h.applyPreview(ctx, item, metadata, err)
+		})
+		if err != nil {
+			logger.FromContext(ctx).Warnw("preview skipped", "id", item.ShortURL, "error", err)
+		}
+	}
+}
+
+func (h *Handler) applyPreview(ctx context.Context, item storage.URLStore, metadata preview.Metadata, err error) {
+	log := logger.FromContext(ctx)
+	if err != nil {
+		log.Debugw("fetch preview failed", "url", item.OriginalURL, "error", err)
+		return
+	}
+
+	current, err := h.Store.GetURL(ctx, item.Domain, item.ShortURL)
+	if err != nil {
+		log.Debugw("preview link lookup failed", "id", item.ShortURL, "error", err)
+		return
+	}
+	var update storage.URLUpdate
+	if current.Title == "" && metadata.Title != "" {
+		title := truncate(metadata.Title, maxTitleLength)
+		update.Title = &title
+	}
+	if current.Description == "" && metadata.Description != "" {
+		description := truncate(metadata.Description, maxDescriptionLength)
+		update.Description = &description
+	}
+	if update.Title == nil && update.Description == nil {
+		return
+	}
+	if _, err := h.Store.UpdateURL(ctx, item.Domain, item.ShortURL, update); err != nil {
+		log.Errorw("save preview failed", "id", item.ShortURL, "error", err)
+	}
+}
+
+func truncate(s string, maxLength int) string {
+	if utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+	return string([]rune(s)[:maxLength])
+}
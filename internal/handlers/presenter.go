@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+)
+
+// Short URLs are built here from the domain of each link, storage only keeps
+// the codes.
+type (
+	batchResult struct {
+		CorrelationID string    `json:"correlation_id"`
+		Domain        string    `json:"domain,omitempty"`
+		ShortURL      string    `json:"short_url"`
+		OriginalURL   string    `json:"original_url"`
+		UserID        string    `json:"user_id,omitempty"`
+		CreatedAt     time.Time `json:"created_at"`
+	}
+
+	userURL struct {
+		Domain      string        `json:"domain,omitempty"`
+		ShortURL    string        `json:"short_url"`
+		OriginalURL string        `json:"original_url"`
+		Title       string        `json:"title,omitempty"`
+		Description string        `json:"description,omitempty"`
+		Tags        []string      `json:"tags,omitempty"`
+		Clicks      int64         `json:"clicks"`
+		Variants    []variantStat `json:"variants,omitempty"`
+		CreatedAt   time.Time     `json:"created_at"`
+	}
+	variantStat struct {
+		Destination string `json:"destination"`
+		Weight      int    `json:"weight"`
+		Clicks      int64  `json:"clicks"`
+	}
+
+	adminURL struct {
+		ID          string          `json:"id"`
+		Domain      string          `json:"domain"`
+		ShortURL    string          `json:"short_url"`
+		OriginalURL string          `json:"original_url"`
+		UserID      string          `json:"user_id,omitempty"`
+		Deleted     bool            `json:"is_deleted"`
+		Disabled    bool            `json:"is_disabled"`
+		Passthrough string          `json:"passthrough,omitempty"`
+		Prefix      bool            `json:"is_prefix"`
+		Rules       []rules.Rule    `json:"rules,omitempty"`
+		Split       []rules.Variant `json:"split,omitempty"`
+		Protected   bool            `json:"is_protected"`
+		MaxClicks   int             `json:"max_clicks,omitempty"`
+		Clicks      int             `json:"clicks,omitempty"`
+		Title       string          `json:"title,omitempty"`
+		Description string          `json:"description,omitempty"`
+		Tags        []string        `json:"tags,omitempty"`
+		CreatedAt   time.Time       `json:"created_at"`
+	}
+)
+
+func (h *Handler) shortURL(item storage.URLStore) string {
+	return h.Domains.Get(item.Domain).ShortURL(item.ShortURL)
+}
+
+func (h *Handler) newBatchResult(item storage.URLStore) batchResult {
+	return batchResult{
+		CorrelationID: item.CorrelationID,
+		Domain:        item.Domain,
+		ShortURL:      h.shortURL(item),
+		OriginalURL:   item.OriginalURL,
+		UserID:        item.UserID,
+		CreatedAt:     item.CreatedAt,
+	}
+}
+
+func (h *Handler) newUserURL(item storage.URLStore) userURL {
+	return userURL{
+		Domain:      item.Domain,
+		ShortURL:    h.shortURL(item),
+		OriginalURL: item.OriginalURL,
+		Title:       item.Title,
+		Description: item.Description,
+		Tags:        item.Tags,
+		Clicks:      item.TotalClicks,
+		CreatedAt:   item.CreatedAt,
+	}
+}
+
+func (h *Handler) newAdminURL(item storage.URLStore) adminURL {
+	return adminURL{
+		ID:          item.ShortURL,
+		Domain:      item.Domain,
+		ShortURL:    h.shortURL(item),
+		OriginalURL: item.OriginalURL,
+		UserID:      item.UserID,
+		Deleted:     item.DeletedFlag,
+		Disabled:    item.Disabled,
+		Passthrough: item.Passthrough,
+		Prefix:      item.Prefix,
+		Rules:       item.Rules,
+		Split:       item.Split,
+		Protected:   item.PasswordHash != "",
+		MaxClicks:   item.MaxClicks,
+		Clicks:      item.Clicks,
+		Title:       item.Title,
+		Description: item.Description,
+		Tags:        item.Tags,
+		CreatedAt:   item.CreatedAt,
+	}
+}
+
+func (h *Handler) conflictProblem(conflict storage.ConflictError) error {
+	return storageProblem(conflict).With("result", h.shortURL(conflict.URL))
+}
+
+func respond(w http.ResponseWriter, r *http.Request, err error) {
+	problem.Respond(w, r, storageProblem(err))
+}
+
+func storageProblem(err error) *problem.Error {
+	var e *problem.Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.As(err, &storage.ConflictError{}):
+		return problem.Wrap(problem.Conflict, err, "URL has already been shortened.")
+	case errors.Is(err, storage.ErrCodeTaken):
+		return problem.Wrap(problem.Conflict, err, "Short code is already taken.")
+	case errors.Is(err, storage.ErrURLNotFound):
+		return problem.Wrap(problem.NotFound, err, "Short URL not found.")
+	case errors.Is(err, storage.ErrURLDeleted):
+		return problem.Wrap(problem.Gone, err, "Short URL has been deleted.")
+	case errors.Is(err, storage.ErrURLDisabled):
+		return problem.Wrap(problem.Gone, err, "Short URL has been disabled.")
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return problem.Wrap(problem.RateLimited, err, "API key quota exceeded.")
+	}
+	return problem.From(err)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// maxPasswordLength is the most bcrypt looks at.
+	maxPasswordLength   = 72
+	maxPasswordFormSize = 4 << 10
+)
+
+// passwordForm posts back to its own URL to keep the query string.
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>This link is protected by a password.</p>
+{{if .}}<p role="alert">{{.}}</p>
+{{end}}<label>Password <input type="password" name="password" autocomplete="off" autofocus required></label>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > maxPasswordLength {
+		return "", problem.New(problem.Validation, "Password must be at most %d bytes.", maxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func validClicks(n int) error {
+	if n < 0 {
+		return problem.New(problem.Validation, "Click counts must not be negative.")
+	}
+	return nil
+}
+
+// password answers requests that do not post a password with the form.
+func password(w http.ResponseWriter, req *http.Request) (string, bool) {
+	if req.Method != http.MethodPost {
+		renderPasswordForm(w, req, http.StatusOK, "")
+		return "", false
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, maxPasswordFormSize)
+	return req.PostFormValue("password"), true
+}
+
+func renderPasswordForm(w http.ResponseWriter, req *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := passwordForm.Execute(w, message); err != nil {
+		logger.FromContext(req.Context()).Errorw("write password form failed", "error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/qrcode"
+	"github.com/go-chi/chi/v5"
+)
+
+const qrCacheControl = "public, max-age=86400"
+
+func (h *Handler) QRCode(w http.ResponseWriter, req *http.Request) {
+	opts, err := qrcode.ParseOptions(req.URL.Query())
+	if err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, err.Error()))
+		return
+	}
+
+	id := chi.URLParam(req, "id")
+	if _, err := h.resolve(req, id); err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	shortURL := h.Domains.ForHost(req.Host).ShortURL(id)
+	etag := opts.ETag(shortURL)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", qrCacheControl)
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	content, err := qrcode.Render(shortURL, opts)
+	if err != nil {
+		respond(w, req, fmt.Errorf("render qr code: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// LinkQRCode redirects for prefix links, /qr is their path then.
+func (h *Handler) LinkQRCode(w http.ResponseWriter, req *http.Request) {
+	if item, err := h.resolve(req, chi.URLParam(req, "id")); err == nil && item.Prefix {
+		h.Expand(w, req)
+		return
+	}
+	h.QRCode(w, req)
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/alexch365/go-url-shortener/internal/audit"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) AdminGetRules(w http.ResponseWriter, req *http.Request) {
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	item, err := h.Store.GetURL(req.Context(), domain.Name, chi.URLParam(req, "id"))
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	util.JSONResponse(w, ruleList(item.Rules), http.StatusOK)
+}
+
+func (h *Handler) AdminSetRules(w http.ResponseWriter, req *http.Request) {
+	var list []rules.Rule
+	if err := json.NewDecoder(req.Body).Decode(&list); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid request format."))
+		return
+	}
+	if err := rules.Validate(list); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, err.Error()))
+		return
+	}
+	for _, destination := range rules.Destinations(list) {
+		if err := h.Validator.URL(destination); err != nil {
+			respond(w, req, urlProblem(err, destination))
+			return
+		}
+	}
+
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	id := chi.URLParam(req, "id")
+	before, err := h.Store.GetURL(req.Context(), domain.Name, id)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	after, err := h.Store.UpdateURL(req.Context(), domain.Name, id, storage.URLUpdate{Rules: &list})
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	oldValue, _ := json.Marshal(ruleList(before.Rules))
+	newValue, _ := json.Marshal(ruleList(after.Rules))
+	if string(oldValue) != string(newValue) {
+		audit.Record(req.Context(), h.Store, storage.Event{Type: storage.EventUpdated, Domain: after.Domain,
+			ShortURL: id, UserID: after.UserID, Field: "rules", OldValue: string(oldValue), NewValue: string(newValue)})
+	}
+	util.JSONResponse(w, ruleList(after.Rules), http.StatusOK)
+}
+
+func ruleList(list []rules.Rule) []rules.Rule {
+	if list == nil {
+		return []rules.Rule{}
+	}
+	return list
+}
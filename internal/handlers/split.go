@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+
+	"github.com/alexch365/go-url-shortener/internal/audit"
+	"github.com/alexch365/go-url-shortener/internal/links"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/proxy"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	visitorCookie       = "visitor_id"
+	visitorCookieMaxAge = 365 * 24 * 60 * 60
+	maxVisitorIDLength  = 64
+)
+
+func (h *Handler) AdminGetSplit(w http.ResponseWriter, req *http.Request) {
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	item, err := h.Store.GetURL(req.Context(), domain.Name, chi.URLParam(req, "id"))
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	variants, err := h.variantStats(req, item)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	util.JSONResponse(w, variants, http.StatusOK)
+}
+
+func (h *Handler) AdminSetSplit(w http.ResponseWriter, req *http.Request) {
+	var split []rules.Variant
+	if err := json.NewDecoder(req.Body).Decode(&split); err != nil {
+		respond(w, req, problem.Wrap(problem.Validation, err, "Invalid request format."))
+		return
+	}
+	if err := h.validSplit(split); err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	domain, err := h.adminDomain(req)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	id := chi.URLParam(req, "id")
+	before, err := h.Store.GetURL(req.Context(), domain.Name, id)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	after, err := h.Store.UpdateURL(req.Context(), domain.Name, id, storage.URLUpdate{Split: &split})
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+
+	oldValue, _ := json.Marshal(variantList(before.Split))
+	newValue, _ := json.Marshal(variantList(after.Split))
+	if string(oldValue) != string(newValue) {
+		audit.Record(req.Context(), h.Store, storage.Event{Type: storage.EventUpdated, Domain: after.Domain,
+			ShortURL: id, UserID: after.UserID, Field: "split", OldValue: string(oldValue), NewValue: string(newValue)})
+	}
+	variants, err := h.variantStats(req, after)
+	if err != nil {
+		respond(w, req, err)
+		return
+	}
+	util.JSONResponse(w, variants, http.StatusOK)
+}
+
+func (h *Handler) validSplit(split []rules.Variant) error {
+	if err := rules.ValidateSplit(split); err != nil {
+		return problem.Wrap(problem.Validation, err, err.Error())
+	}
+	for _, variant := range split {
+		if err := h.Validator.URL(variant.Destination); err != nil {
+			return urlProblem(err, variant.Destination)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) variantStats(req *http.Request, item storage.URLStore) ([]variantStat, error) {
+	stats := make([]variantStat, 0, len(item.Split))
+	if len(item.Split) == 0 {
+		return stats, nil
+	}
+	clicks, err := h.Store.ListClicks(req.Context(), item.Domain, item.ShortURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, variant := range item.Split {
+		stats = append(stats, variantStat{
+			Destination: variant.Destination,
+			Weight:      variant.Weight,
+			Clicks:      clicks[variant.Destination],
+		})
+	}
+	return stats, nil
+}
+
+// roll only hands out the visitor cookie for split links.
+func (h *Handler) roll(w http.ResponseWriter, req *http.Request, item storage.URLStore) float64 {
+	if !hasSplit(item) {
+		return links.Roll("", item)
+	}
+	var visitorID string
+	if cookie, err := req.Cookie(visitorCookie); err == nil && len(cookie.Value) <= maxVisitorIDLength {
+		visitorID = cookie.Value
+	}
+	if visitorID == "" {
+		visitorID = util.RandomString(16)
+		http.SetCookie(w, &http.Cookie{
+			Name:     visitorCookie,
+			Value:    visitorID,
+			Path:     cmp.Or(h.Config.Get().PathPrefix, "/"),
+			MaxAge:   visitorCookieMaxAge,
+			Secure:   proxy.Scheme(req) == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return links.Roll(visitorID, item)
+}
+
+func hasSplit(item storage.URLStore) bool {
+	if len(item.Split) > 0 {
+		return true
+	}
+	for _, rule := range item.Rules {
+		if len(rule.Split) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func variantList(split []rules.Variant) []rules.Variant {
+	if split == nil {
+		return []rules.Variant{}
+	}
+	return split
+}
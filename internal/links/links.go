@@ -0,0 +1,97 @@
+// Package links follows short links for both the HTTP and the gRPC API.
+package links
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+
+	"github.com/alexch365/go-url-shortener/internal/audit"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/redirect"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordRequired = errors.New("password required")
+	ErrWrongPassword    = errors.New("wrong password")
+)
+
+type Visit struct {
+	Path     string
+	Query    url.Values
+	Password string
+	Visitor  rules.Visitor
+}
+
+// Lookup fails with ErrURLNotFound for a path below a link that is not a
+// prefix link.
+func Lookup(ctx context.Context, store storage.StoreHandler, domain, id, path string) (storage.URLStore, error) {
+	item, err := store.Get(ctx, domain, id)
+	if err == nil && path != "" && !item.Prefix {
+		return storage.URLStore{}, storage.ErrURLNotFound
+	}
+	return item, err
+}
+
+func Follow(ctx context.Context, store storage.StoreHandler, item storage.URLStore, visit Visit) (string, error) {
+	if item.PasswordHash != "" {
+		if visit.Password == "" {
+			return "", ErrPasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(item.PasswordHash), []byte(visit.Password)) != nil {
+			logger.FromContext(ctx).Debugw("wrong link password", "id", item.ShortURL)
+			return "", ErrWrongPassword
+		}
+	}
+	if item.MaxClicks > 0 {
+		var err error
+		if item, err = useClick(ctx, store, item); err != nil {
+			return "", err
+		}
+	}
+
+	destination, split := rules.Match(item.Rules, rules.Rule{Destination: item.OriginalURL, Split: item.Split},
+		visit.Visitor)
+	if split {
+		recordClick(ctx, store, item, destination)
+	}
+	recordClick(ctx, store, item, storage.AllVariants)
+
+	target, err := redirect.Target(destination, item.Passthrough, redirect.Request{Path: visit.Path, Query: visit.Query})
+	if err != nil {
+		return "", fmt.Errorf("build redirect target: %w", err)
+	}
+	return target, nil
+}
+
+func Roll(visitorID string, item storage.URLStore) float64 {
+	if visitorID == "" {
+		return rand.Float64()
+	}
+	return rules.Roll(visitorID + "/" + item.Domain + "/" + item.ShortURL)
+}
+
+func useClick(ctx context.Context, store storage.StoreHandler, item storage.URLStore) (storage.URLStore, error) {
+	used, err := store.UseClick(ctx, item.Domain, item.ShortURL)
+	if err != nil {
+		return used, err
+	}
+	if used.Disabled {
+		audit.Record(ctx, store, storage.Event{Type: storage.EventExpired, Domain: used.Domain,
+			ShortURL: used.ShortURL, UserID: used.UserID, Field: "max_clicks", NewValue: strconv.Itoa(used.MaxClicks)})
+	}
+	return used, nil
+}
+
+// recordClick never fails the redirect.
+func recordClick(ctx context.Context, store storage.StoreHandler, item storage.URLStore, variant string) {
+	if err := store.RecordClick(ctx, item.Domain, item.ShortURL, variant); err != nil {
+		logger.FromContext(ctx).Errorw("record click failed", "id", item.ShortURL, "error", err)
+	}
+}
@@ -0,0 +1,129 @@
+package links
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/redirect"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestStore(t *testing.T) *storage.MemoryStore {
+	cfg := config.Default()
+	dir := t.TempDir()
+	cfg.FileStoragePath = dir + "/urls.json"
+	cfg.APIKeysPath = dir + "/api_keys.json"
+	cfg.EventLogPath = dir + "/events.json"
+	cfg.ClickLogPath = dir + "/clicks.json"
+	store := &storage.MemoryStore{Config: config.New(cfg)}
+	require.NoError(t, store.Initialize(context.TODO()))
+	return store
+}
+
+func TestFollow(t *testing.T) {
+	t.Parallel()
+	store := newTestStore(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	for _, item := range []storage.URLStore{
+		{ShortURL: "plain", OriginalURL: "https://go.dev"},
+		{ShortURL: "docs", OriginalURL: "https://go.dev/{path}", Prefix: true, Passthrough: redirect.PassthroughMerge},
+		{ShortURL: "locked", OriginalURL: "https://go.dev/ref", PasswordHash: string(hash)},
+		{ShortURL: "ruled", OriginalURL: "https://go.dev/blog", Rules: []rules.Rule{
+			{Languages: []string{"de"}, Destination: "https://go.dev/de"},
+		}},
+	} {
+		_, err := store.Save(context.TODO(), item)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		visit    Visit
+		want     string
+		wantErr  error
+		language string
+	}{
+		{name: "plain", id: "plain", want: "https://go.dev"},
+		{name: "path after a plain link", id: "plain", visit: Visit{Path: "doc"}, wantErr: storage.ErrURLNotFound},
+		{name: "prefix with query", id: "docs", visit: Visit{Path: "doc/install", Query: url.Values{"a": {"1"}}},
+			want: "https://go.dev/doc/install?a=1"},
+		{name: "missing password", id: "locked", wantErr: ErrPasswordRequired},
+		{name: "wrong password", id: "locked", visit: Visit{Password: "guess"}, wantErr: ErrWrongPassword},
+		{name: "right password", id: "locked", visit: Visit{Password: "secret"}, want: "https://go.dev/ref"},
+		{name: "matching rule", id: "ruled", language: "de", want: "https://go.dev/de"},
+		{name: "no matching rule", id: "ruled", language: "en", want: "https://go.dev/blog"},
+		{name: "unknown", id: "missing", wantErr: storage.ErrURLNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := Lookup(context.TODO(), store, "", tt.id, tt.visit.Path)
+			if err == nil {
+				tt.visit.Visitor.Languages = []string{tt.language}
+				var target string
+				target, err = Follow(context.TODO(), store, item, tt.visit)
+				assert.Equal(t, tt.want, target)
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestFollowCounts(t *testing.T) {
+	t.Parallel()
+	store := newTestStore(t)
+	item, err := store.Save(context.TODO(), storage.URLStore{ShortURL: "split", OriginalURL: "https://go.dev",
+		MaxClicks: 2, Split: []rules.Variant{
+			{Destination: "https://go.dev/a", Weight: 1},
+			{Destination: "https://go.dev/b", Weight: 1},
+		}})
+	require.NoError(t, err)
+
+	for _, roll := range []float64{0.1, 0.9} {
+		item, err = Lookup(context.TODO(), store, "", "split", "")
+		require.NoError(t, err)
+		_, err = Follow(context.TODO(), store, item, Visit{Visitor: rules.Visitor{Roll: roll}})
+		require.NoError(t, err)
+	}
+	_, err = Lookup(context.TODO(), store, "", "split", "")
+	assert.ErrorIs(t, err, storage.ErrURLDisabled)
+
+	clicks, err := store.ListClicks(context.TODO(), "", "split")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{storage.AllVariants: 2, "https://go.dev/a": 1, "https://go.dev/b": 1}, clicks)
+	events, err := store.ListEvents(context.TODO(), storage.EventFilter{ShortURL: "split"})
+	require.NoError(t, err)
+	require.Len(t, events.Events, 1)
+	assert.Equal(t, storage.EventExpired, events.Events[0].Type)
+}
+
+func TestFollowCountsRuleSplit(t *testing.T) {
+	t.Parallel()
+	store := newTestStore(t)
+	item, err := store.Save(context.TODO(), storage.URLStore{ShortURL: "ruled", OriginalURL: "https://go.dev",
+		Rules: []rules.Rule{{Languages: []string{"de"}, Split: []rules.Variant{
+			{Destination: "https://go.dev/de/a", Weight: 1},
+			{Destination: "https://go.dev/de/b", Weight: 1},
+		}}}})
+	require.NoError(t, err)
+
+	for _, visitor := range []rules.Visitor{
+		{Languages: []string{"de"}, Roll: 0.1},
+		{Languages: []string{"de"}, Roll: 0.9},
+		{Languages: []string{"en"}, Roll: 0.1},
+	} {
+		_, err = Follow(context.TODO(), store, item, Visit{Visitor: visitor})
+		require.NoError(t, err)
+	}
+
+	clicks, err := store.ListClicks(context.TODO(), "", "ruled")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{storage.AllVariants: 3, "https://go.dev/de/a": 1, "https://go.dev/de/b": 1}, clicks)
+}
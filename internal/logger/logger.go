@@ -1,14 +1,34 @@
 package logger
 
 import (
-	"go.uber.org/zap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/proxy"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-var Log *zap.SugaredLogger
+const RequestIDHeader = "X-Request-ID"
+
+var nop = zap.NewNop().Sugar()
 
 type (
+	Logger struct {
+		*zap.SugaredLogger
+		level    zap.AtomicLevel
+		redirect atomic.Pointer[zap.SugaredLogger]
+	}
+
+	contextKey int
+
 	responseData struct {
 		status int
 		size   int
@@ -20,6 +40,11 @@ type (
 	}
 )
 
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
 func (r *loggingResponseWriter) Write(b []byte) (int, error) {
 	size, err := r.ResponseWriter.Write(b)
 	r.size += size
@@ -31,32 +56,140 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.status = statusCode
 }
 
-func Initialize() error {
-	logger, err := zap.NewProduction()
+func New(cfg *config.Config) (*Logger, error) {
+	parsed, err := zapcore.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var zapConfig zap.Config
+	switch cfg.LogFormat {
+	case "json":
+		zapConfig = zap.NewProductionConfig()
+	case "console":
+		zapConfig = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", cfg.LogFormat)
+	}
+	zapConfig.Level = zap.NewAtomicLevelAt(parsed)
+	zapConfig.Sampling = nil
+
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
 	}
 
 	defer logger.Sync()
 
-	Log = logger.Sugar()
-	return nil
+	log := &Logger{SugaredLogger: logger.Sugar(), level: zapConfig.Level}
+	log.setRedirectSampling(cfg.LogRedirectSampling)
+	return log, nil
+}
+
+func Nop() *Logger {
+	log := &Logger{SugaredLogger: nop, level: zap.NewAtomicLevel()}
+	log.redirect.Store(nop)
+	return log
 }
 
-func Middleware(next http.Handler) http.Handler {
+// Reload leaves the output format as it was at startup.
+func (l *Logger) Reload(cfg *config.Config) {
+	if parsed, err := zapcore.ParseLevel(cfg.LogLevel); err == nil {
+		l.level.SetLevel(parsed)
+	}
+	l.setRedirectSampling(cfg.LogRedirectSampling)
+}
+
+func (l *Logger) setRedirectSampling(rate int) {
+	log := l.SugaredLogger
+	if rate > 1 {
+		log = log.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSamplerWithOptions(core, time.Second, rate, rate)
+		}))
+	}
+	l.redirect.Store(log)
+}
+
+func WithContext(ctx context.Context, log *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey, log)
+}
+
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if log, ok := ctx.Value(loggerKey).(*zap.SugaredLogger); ok {
+		return log
+	}
+	return nop
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func EnsureRequestID(id string) string {
+	if validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := EnsureRequestID(r.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+func (l *Logger) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		lrw := &loggingResponseWriter{w, &responseData{}}
+		fields := []interface{}{
+			"request_id", RequestID(r.Context()),
+			"trace_id", tracing.TraceID(r.Context()),
+			"span_id", tracing.SpanID(r.Context()),
+		}
+		log := l.With(fields...)
+		lrw := &loggingResponseWriter{w, &responseData{status: http.StatusOK}}
 
-		next.ServeHTTP(lrw, r)
+		next.ServeHTTP(lrw, r.WithContext(WithContext(r.Context(), log)))
 
-		Log.Infow("user request",
+		if r.Method == http.MethodGet && lrw.status == http.StatusTemporaryRedirect {
+			log = l.redirect.Load().With(fields...)
+		}
+		log.Infow("user request",
 			"uri", r.RequestURI,
 			"method", r.Method,
+			"client_ip", proxy.ClientIP(r),
+			"scheme", proxy.Scheme(r),
 			"duration", time.Since(start),
 			"status", lrw.status,
 			"size", lrw.size,
 		)
 	})
 }
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
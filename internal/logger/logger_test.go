@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"with incoming ID", "ci-job-42", "^ci-job-42$"},
+		{"without incoming ID", "", "^[0-9a-f]{32}$"},
+		{"with oversized ID", strings.Repeat("a", 129), "^[0-9a-f]{32}$"},
+		{"with control characters", "bad\nid", "^[0-9a-f]{32}$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contextID string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextID = RequestID(r.Context())
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				request.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			assert.Regexp(t, tt.want, rec.Header().Get(RequestIDHeader))
+			assert.Equal(t, rec.Header().Get(RequestIDHeader), contextID)
+		})
+	}
+}
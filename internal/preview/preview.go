@@ -0,0 +1,234 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	maxConcurrent  = 8
+	maxQueued      = 1024
+	// The head is near the start of a page.
+	maxBodySize = 1 << 20
+	userAgent   = "go-url-shortener-preview/1.0"
+)
+
+var (
+	ErrBusy       = errors.New("too many previews queued")
+	ErrNotHTML    = errors.New("destination is not an HTML page")
+	ErrPrivateIP  = errors.New("destination resolves to a private address")
+	errHeadParsed = errors.New("head parsed")
+)
+
+type (
+	// Metadata prefers OpenGraph properties over the title and description tags.
+	Metadata struct {
+		Title       string
+		Description string
+	}
+
+	// Fetcher refuses private addresses unless AllowPrivate is set, so links
+	// cannot probe internal networks.
+	Fetcher struct {
+		Client       *http.Client
+		AllowPrivate bool
+
+		slots   chan struct{}
+		queue   chan job
+		workers sync.Once
+	}
+
+	job struct {
+		ctx    context.Context
+		rawURL string
+		done   func(Metadata, error)
+	}
+)
+
+func NewFetcher() *Fetcher {
+	f := &Fetcher{slots: make(chan struct{}, maxConcurrent), queue: make(chan job, maxQueued)}
+	dialer := &net.Dialer{Timeout: defaultTimeout, Control: f.checkAddress}
+	f.Client = &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: defaultTimeout,
+			MaxIdleConns:        maxConcurrent,
+			IdleConnTimeout:     time.Minute,
+		},
+	}
+	return f
+}
+
+// Queue only fails with ErrBusy when the queue is full.
+func (f *Fetcher) Queue(ctx context.Context, rawURL string, done func(Metadata, error)) error {
+	f.workers.Do(func() {
+		for range maxConcurrent {
+			go f.work()
+		}
+	})
+	select {
+	case f.queue <- job{ctx: ctx, rawURL: rawURL, done: done}:
+		return nil
+	default:
+		return ErrBusy
+	}
+}
+
+func (f *Fetcher) work() {
+	for j := range f.queue {
+		j.done(f.Fetch(j.ctx, j.rawURL))
+	}
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Metadata, error) {
+	select {
+	case f.slots <- struct{}{}:
+		defer func() { <-f.slots }()
+	case <-ctx.Done():
+		return Metadata{}, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return Metadata{}, fmt.Errorf("unsupported scheme: %s", req.URL.Scheme)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Metadata{}, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" &&
+		mediaType != "application/xhtml+xml" {
+		return Metadata{}, fmt.Errorf("%w: %s", ErrNotHTML, contentType)
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxBodySize), contentType)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return Parse(body)
+}
+
+func Parse(r io.Reader) (Metadata, error) {
+	var title, ogTitle, description, ogDescription string
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+	err := func() error {
+		for {
+			switch tokenizer.Next() {
+			case html.ErrorToken:
+				return tokenizer.Err()
+			case html.StartTagToken, html.SelfClosingTagToken:
+				name, hasAttr := tokenizer.TagName()
+				switch string(name) {
+				case "title":
+					inTitle = title == ""
+				case "meta":
+					if !hasAttr {
+						continue
+					}
+					key, content := metaAttributes(tokenizer)
+					switch key {
+					case "og:title":
+						ogTitle = content
+					case "description":
+						description = content
+					case "og:description":
+						ogDescription = content
+					}
+				case "body":
+					return errHeadParsed
+				}
+			case html.EndTagToken:
+				name, _ := tokenizer.TagName()
+				switch string(name) {
+				case "title":
+					inTitle = false
+				case "head":
+					return errHeadParsed
+				}
+			case html.TextToken:
+				if inTitle {
+					title += string(tokenizer.Text())
+				}
+			}
+		}
+	}()
+	if err != nil && err != io.EOF && err != errHeadParsed {
+		return Metadata{}, err
+	}
+	return Metadata{
+		Title:       clean(firstOf(ogTitle, title)),
+		Description: clean(firstOf(ogDescription, description)),
+	}, nil
+}
+
+func metaAttributes(tokenizer *html.Tokenizer) (key, content string) {
+	for {
+		name, value, more := tokenizer.TagAttr()
+		switch string(name) {
+		case "name", "property":
+			key = strings.ToLower(string(value))
+		case "content":
+			content = string(value)
+		}
+		if !more {
+			return key, content
+		}
+	}
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// checkAddress runs after name resolution, redirects included.
+func (f *Fetcher) checkAddress(_, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsUnspecified() || addr.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrPrivateIP, addr)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		page string
+		want Metadata
+	}{
+		{"title and description",
+			`<html><head><title>
+				Docs  home
+			</title><meta name="description" content="All the docs."></head></html>`,
+			Metadata{Title: "Docs home", Description: "All the docs."}},
+		{"opengraph wins",
+			`<head><title>Plain</title><meta property="og:title" content="Rich">
+			<meta name="Description" content="plain"><meta property="og:description" content="rich"></head>`,
+			Metadata{Title: "Rich", Description: "rich"}},
+		{"empty opengraph falls back", `<title>Plain</title><meta property="og:title" content=" ">`,
+			Metadata{Title: "Plain"}},
+		{"body is not read", `<head></head><body><title>Not a title</title></body>`, Metadata{}},
+		{"entities", `<title>Fish &amp; chips</title>`, Metadata{Title: "Fish & chips"}},
+		{"nothing", `plain text`, Metadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.page))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		w.Write([]byte("<title>\xcf\xf0\xe8\xe2\xe5\xf2</title>"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.AllowPrivate = true
+
+	got, err := fetcher.Fetch(context.Background(), server.URL+"/moved")
+	require.NoError(t, err)
+	assert.Equal(t, Metadata{Title: "Привет"}, got)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/image")
+	assert.ErrorIs(t, err, ErrNotHTML)
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/missing")
+	assert.Error(t, err)
+	_, err = fetcher.Fetch(context.Background(), "ftp://example.com/file")
+	assert.Error(t, err)
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address was fetched")
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher().Fetch(context.Background(), server.URL)
+	assert.True(t, errors.Is(err, ErrPrivateIP), err)
+}
+
+func TestFetchWaitsForSlot(t *testing.T) {
+	t.Parallel()
+	fetcher := NewFetcher()
+	for range maxConcurrent {
+		fetcher.slots <- struct{}{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := fetcher.Fetch(ctx, "https://example.com")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Queued</title>`))
+	}))
+	defer server.Close()
+	fetcher := NewFetcher()
+	fetcher.AllowPrivate = true
+
+	var wg sync.WaitGroup
+	var fetched atomic.Int32
+	for range 4 * maxConcurrent {
+		wg.Add(1)
+		err := fetcher.Queue(context.Background(), server.URL, func(metadata Metadata, err error) {
+			defer wg.Done()
+			if err == nil && metadata.Title == "Queued" {
+				fetched.Add(1)
+			}
+		})
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	assert.EqualValues(t, 4*maxConcurrent, fetched.Load())
+}
+
+func TestQueueFull(t *testing.T) {
+	t.Parallel()
+	fetcher := NewFetcher()
+	for range maxConcurrent {
+		fetcher.slots <- struct{}{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var err error
+	for range maxQueued + maxConcurrent + 1 {
+		if err = fetcher.Queue(ctx, "https://example.com", func(Metadata, error) {}); err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, ErrBusy)
+}
@@ -0,0 +1,167 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/alexch365/go-url-shortener/internal/logger"
+)
+
+const ContentType = "application/problem+json"
+
+type Kind string
+
+const (
+	Validation   Kind = "validation"
+	Unauthorized Kind = "unauthorized"
+	Forbidden    Kind = "forbidden"
+	NotFound     Kind = "not_found"
+	Conflict     Kind = "conflict"
+	Gone         Kind = "gone"
+	TooLarge     Kind = "too_large"
+	RateLimited  Kind = "rate_limited"
+	Internal     Kind = "internal"
+)
+
+var statuses = map[Kind]int{
+	Validation:   http.StatusBadRequest,
+	Unauthorized: http.StatusUnauthorized,
+	Forbidden:    http.StatusForbidden,
+	NotFound:     http.StatusNotFound,
+	Conflict:     http.StatusConflict,
+	Gone:         http.StatusGone,
+	TooLarge:     http.StatusRequestEntityTooLarge,
+	RateLimited:  http.StatusTooManyRequests,
+	Internal:     http.StatusInternalServerError,
+}
+
+type (
+	Error struct {
+		Kind       Kind
+		Detail     string
+		Extensions map[string]any
+		Err        error
+	}
+
+	Problem struct {
+		Type       string         `json:"type"`
+		Title      string         `json:"title"`
+		Status     int            `json:"status"`
+		Kind       Kind           `json:"kind"`
+		Detail     string         `json:"detail,omitempty"`
+		Instance   string         `json:"instance,omitempty"`
+		Extensions map[string]any `json:"-"`
+	}
+)
+
+func New(kind Kind, format string, args ...any) *Error {
+	return &Error{Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
+
+func Wrap(kind Kind, err error, detail string) *Error {
+	return &Error{Kind: kind, Detail: detail, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Kind, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) With(key string, value any) *Error {
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]any)
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// From never shows the cause of an internal error to the client.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Wrap(Internal, err, "Internal server error.")
+}
+
+// MarshalJSON lets the standard members win over extensions.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type standard Problem
+	body, err := json.Marshal(standard(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+	members := make(map[string]any, len(p.Extensions)+6)
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+func Respond(w http.ResponseWriter, r *http.Request, err error) {
+	e := From(err)
+	status := e.Status()
+	if status >= http.StatusInternalServerError {
+		logger.FromContext(r.Context()).Errorw("request failed", "kind", e.Kind, "error", e)
+	}
+
+	if !wantsJSON(r) {
+		http.Error(w, e.Detail, status)
+		return
+	}
+
+	body := Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(status),
+		Status:     status,
+		Kind:       e.Kind,
+		Detail:     e.Detail,
+		Instance:   r.URL.Path,
+		Extensions: e.Extensions,
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func wantsJSON(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			return true
+		}
+		if strings.HasPrefix(mediaType, "text/") {
+			return false
+		}
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	}
+	return strings.Contains(r.URL.Path, "/api/")
+}
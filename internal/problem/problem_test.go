@@ -0,0 +1,72 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		kind   Kind
+		status int
+	}{
+		{"typed error", New(Validation, "bad"), Validation, http.StatusBadRequest},
+		{"wrapped typed error", fmt.Errorf("save: %w", New(Gone, "gone")), Gone, http.StatusGone},
+		{"unknown", errors.New("boom"), Internal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			assert.Equal(t, tt.kind, e.Kind)
+			assert.Equal(t, tt.status, e.Status())
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		contentType string
+		wantType    string
+	}{
+		{"text endpoint", "/", "", "text/plain", "text/plain; charset=utf-8"},
+		{"text endpoint accepting JSON", "/", "application/json", "text/plain", ContentType},
+		{"API endpoint", "/api/shorten", "", "", ContentType},
+		{"API endpoint accepting text", "/api/shorten", "text/plain", "application/json", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			Respond(rec, request, New(Conflict, "URL has already been shortened.").With("result", "http://localhost/abc"))
+
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Equal(t, tt.wantType, rec.Header().Get("Content-Type"))
+			if tt.wantType != ContentType {
+				return
+			}
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+			assert.Equal(t, "conflict", body["kind"])
+			assert.Equal(t, "http://localhost/abc", body["result"])
+			assert.Equal(t, tt.path, body["instance"])
+		})
+	}
+}
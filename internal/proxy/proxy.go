@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	Trusted struct {
+		networks atomic.Pointer[[]netip.Prefix]
+	}
+
+	hop struct {
+		addr  string
+		proto string
+		host  string
+	}
+)
+
+func New(entries []string) (*Trusted, error) {
+	trusted := &Trusted{}
+	if err := trusted.Set(entries); err != nil {
+		return nil, err
+	}
+	return trusted, nil
+}
+
+func ParseNetworks(entries []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			network, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			networks = append(networks, network.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a network", entry)
+		}
+		networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return networks, nil
+}
+
+func (t *Trusted) Set(entries []string) error {
+	networks, err := ParseNetworks(entries)
+	if err != nil {
+		return err
+	}
+	t.networks.Store(&networks)
+	return nil
+}
+
+func (t *Trusted) trusts(addr netip.Addr) bool {
+	for _, network := range *t.networks.Load() {
+		if network.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware walks the address chain from the right, the first untrusted
+// address is the client.
+func (t *Trusted) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, ok := parseAddr(r.RemoteAddr)
+		if !ok || !t.trusts(peer) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hops := forwarded(r.Header)
+		if len(hops) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, edge := peer, hops[len(hops)-1]
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseAddr(hops[i].addr)
+			if !ok {
+				break
+			}
+			client, edge = addr, hops[i]
+			if !t.trusts(addr) {
+				break
+			}
+		}
+
+		r = r.Clone(r.Context())
+		r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+		if edge.host != "" {
+			r.Host = edge.host
+		}
+		if edge.proto != "" {
+			r.URL.Scheme = edge.proto
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func Scheme(r *http.Request) string {
+	switch {
+	case r.URL.Scheme != "":
+		return r.URL.Scheme
+	case r.TLS != nil:
+		return "https"
+	default:
+		return "http"
+	}
+}
+
+func forwarded(header http.Header) []hop {
+	var hops []hop
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range splitList(values) {
+			var h hop
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					h.addr = value
+				case "proto":
+					h.proto = cleanProto(value)
+				case "host":
+					h.host = cleanHost(value)
+				}
+			}
+			hops = append(hops, h)
+		}
+		return hops
+	}
+
+	for _, addr := range splitList(header.Values("X-Forwarded-For")) {
+		hops = append(hops, hop{addr: addr})
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+	// Proto and host belong to the hop that turns out to be the edge.
+	proto := cleanProto(last(splitList(header.Values("X-Forwarded-Proto"))))
+	host := cleanHost(last(splitList(header.Values("X-Forwarded-Host"))))
+	for i := range hops {
+		hops[i].proto, hops[i].host = proto, host
+	}
+	return hops
+}
+
+func parseAddr(raw string) (netip.Addr, bool) {
+	raw = strings.Trim(strings.TrimSpace(raw), `"`)
+	if addrPort, err := netip.ParseAddrPort(raw); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func cleanProto(proto string) string {
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+	return proto
+}
+
+func cleanHost(host string) string {
+	host = strings.TrimSpace(host)
+	if strings.ContainsAny(host, "/ \\@?#") {
+		return ""
+	}
+	return host
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+func last(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return items[len(items)-1]
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	trusted, err := New([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		wantIP     string
+		wantHost   string
+		wantScheme string
+	}{
+		{"direct client", "203.0.113.5:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Host": {"evil.example"}},
+			"203.0.113.5", "shortener.internal", "http"},
+		{"x-forwarded headers", "10.0.0.2:1234",
+			http.Header{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"corp.example"},
+			},
+			"198.51.100.1", "corp.example", "https"},
+		{"spoofed chain", "10.0.0.2:1234",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.0.0.3"}},
+			"198.51.100.1", "shortener.internal", "http"},
+		{"forwarded header", "192.0.2.1:1234",
+			http.Header{"Forwarded": {`for="[2001:db8::1]:4711";proto=https;host=corp.example, for=10.0.0.3`}},
+			"2001:db8::1", "corp.example", "https"},
+		{"forwarded wins", "10.0.0.2:1234",
+			http.Header{"Forwarded": {"for=198.51.100.2"}, "X-Forwarded-For": {"198.51.100.1"}},
+			"198.51.100.2", "shortener.internal", "http"},
+		{"invalid proto", "10.0.0.2:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"javascript"}},
+			"198.51.100.1", "shortener.internal", "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			handler := trusted.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = r
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			request.Host = "shortener.internal"
+			request.RemoteAddr = tt.remoteAddr
+			request.Header = tt.header
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			require.NotNil(t, got)
+			assert.Equal(t, tt.wantIP, ClientIP(got))
+			assert.Equal(t, tt.wantHost, got.Host)
+			assert.Equal(t, tt.wantScheme, Scheme(got))
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"127.0.0.1", " ::1 ", "10.1.2.3/8", ""})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8", networks[2].String())
+	assert.Len(t, networks, 3)
+
+	_, err = ParseNetworks([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package qrcode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+var ErrInvalidOptions = errors.New("invalid qr code options")
+
+var levels = map[string]qr.Level{"L": qr.L, "M": qr.M, "Q": qr.Q, "H": qr.H}
+
+// Options.Margin is the quiet zone in modules.
+type Options struct {
+	Format string
+	Size   int
+	Level  string
+	Margin int
+}
+
+func DefaultOptions() Options {
+	return Options{Format: FormatPNG, Size: DefaultSize, Level: "M", Margin: DefaultMargin}
+}
+
+func ParseOptions(query url.Values) (Options, error) {
+	opts := DefaultOptions()
+	if value := query.Get("format"); value != "" {
+		opts.Format = strings.ToLower(value)
+	}
+	if value := query.Get("level"); value != "" {
+		opts.Level = strings.ToUpper(value)
+	}
+	var err error
+	if value := query.Get("size"); value != "" {
+		if opts.Size, err = strconv.Atoi(value); err != nil {
+			return opts, fmt.Errorf("%w: size must be a number", ErrInvalidOptions)
+		}
+	}
+	if value := query.Get("margin"); value != "" {
+		if opts.Margin, err = strconv.Atoi(value); err != nil {
+			return opts, fmt.Errorf("%w: margin must be a number", ErrInvalidOptions)
+		}
+	}
+	return opts, opts.validate()
+}
+
+func (o Options) validate() error {
+	switch {
+	case o.Format != FormatPNG && o.Format != FormatSVG:
+		return fmt.Errorf("%w: format must be png or svg", ErrInvalidOptions)
+	case o.Size < MinSize || o.Size > MaxSize:
+		return fmt.Errorf("%w: size must be between %d and %d", ErrInvalidOptions, MinSize, MaxSize)
+	case o.Margin < 0 || o.Margin > MaxMargin:
+		return fmt.Errorf("%w: margin must be between 0 and %d", ErrInvalidOptions, MaxMargin)
+	}
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("%w: level must be one of L, M, Q and H", ErrInvalidOptions)
+	}
+	return nil
+}
+
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// ETag is known without rendering, rendering is deterministic.
+func (o Options) ETag(text string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%d|%s", o.Format, o.Size, o.Level, o.Margin, text)))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+func Render(text string, opts Options) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	code, err := qr.Encode(text, levels[opts.Level])
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format == FormatSVG {
+		return renderSVG(code, opts), nil
+	}
+	return renderPNG(code, opts)
+}
+
+func DataURI(content []byte, opts Options) string {
+	return "data:" + opts.ContentType() + ";base64," + base64.StdEncoding.EncodeToString(content)
+}
+
+// renderPNG splits the leftover pixels evenly between the edges.
+func renderPNG(code *qr.Code, opts Options) ([]byte, error) {
+	modules := code.Size + 2*opts.Margin
+	scale := max(opts.Size/modules, 1)
+	size := max(opts.Size, modules)
+	offset := (size-scale*modules)/2 + opts.Margin*scale
+
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray(offset+x*scale+dx, offset+y*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(code *qr.Code, opts Options) []byte {
+	modules := code.Size + 2*opts.Margin
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" `+
+		`shape-rendering="crispEdges">`, opts.Size, opts.Size, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, modules, modules)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x+opts.Margin, y+opts.Margin)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
@@ -0,0 +1,65 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    Options
+		wantErr bool
+	}{
+		{"defaults", "", DefaultOptions(), false},
+		{"all set", "format=SVG&size=512&level=h&margin=0",
+			Options{Format: FormatSVG, Size: 512, Level: "H", Margin: 0}, false},
+		{"unknown format", "format=gif", Options{}, true},
+		{"too small", "size=10", Options{}, true},
+		{"not a number", "size=big", Options{}, true},
+		{"unknown level", "level=X", Options{}, true},
+		{"negative margin", "margin=-1", Options{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			opts, err := ParseOptions(query)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidOptions)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, opts)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	opts := DefaultOptions()
+	content, err := Render("https://corp.example/s/abcdefgh", opts)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, opts.Size, img.Bounds().Dx())
+	assert.Equal(t, opts.Size, img.Bounds().Dy())
+
+	again, err := Render("https://corp.example/s/abcdefgh", opts)
+	require.NoError(t, err)
+	assert.Equal(t, content, again)
+
+	opts.Format = FormatSVG
+	content, err = Render("https://corp.example/s/abcdefgh", opts)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "<svg"))
+	assert.True(t, strings.HasPrefix(DataURI(content, opts), "data:image/svg+xml;base64,"))
+
+	assert.NotEqual(t, opts.ETag("https://corp.example/s/abcdefgh"), DefaultOptions().ETag("https://corp.example/s/abcdefgh"))
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// pruneInterval drops refilled buckets, they are the same as none.
+const pruneInterval = time.Minute
+
+type (
+	MemoryBackend struct {
+		mu       sync.Mutex
+		buckets  map[string]*memoryBucket
+		now      func() time.Time
+		prunedAt time.Time
+	}
+	memoryBucket struct {
+		bucket
+		limit Limit
+	}
+	// DatabaseBackend uses the rate_limits table of the storage migrations.
+	DatabaseBackend struct {
+		DB *sql.DB
+	}
+)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: make(map[string]*memoryBucket), now: time.Now}
+}
+
+func (backend *MemoryBackend) Take(_ context.Context, key string, limit Limit, n int) (Result, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	now := backend.now()
+	b, ok := backend.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket{tokens: float64(limit.Burst), updatedAt: now}, limit}
+		backend.buckets[key] = b
+	}
+	result := b.take(limit, now, n)
+
+	if now.Sub(backend.prunedAt) >= pruneInterval {
+		backend.prunedAt = now
+		for k, stale := range backend.buckets {
+			if stale.tokens+now.Sub(stale.updatedAt).Seconds()*stale.limit.Rate >= float64(stale.limit.Burst) {
+				delete(backend.buckets, k)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (backend *DatabaseBackend) Take(ctx context.Context, key string, limit Limit, n int) (Result, error) {
+	tx, err := backend.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (key) DO NOTHING
+	`, key, limit.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var b bucket
+	var now time.Time
+	err = tx.QueryRowContext(ctx, `SELECT tokens, updated_at, now() FROM rate_limits WHERE key = $1 FOR UPDATE`, key).
+		Scan(&b.tokens, &b.updatedAt, &now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := b.take(limit, now, n)
+	_, err = tx.ExecContext(ctx, `UPDATE rate_limits SET tokens = $2, updated_at = $3 WHERE key = $1`,
+		key, b.tokens, b.updatedAt)
+	if err != nil {
+		return Result{}, err
+	}
+	return result, tx.Commit()
+}
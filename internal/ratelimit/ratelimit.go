@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/auth"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/problem"
+	"github.com/alexch365/go-url-shortener/internal/proxy"
+)
+
+type (
+	Limit struct {
+		Name  string
+		Rate  float64
+		Burst int
+	}
+	Result struct {
+		Allowed    bool
+		Remaining  int
+		Reset      time.Duration
+		RetryAfter time.Duration
+	}
+	Backend interface {
+		Take(ctx context.Context, key string, limit Limit, n int) (Result, error)
+	}
+	Limiter struct {
+		backend Backend
+		limit   atomic.Pointer[Limit]
+	}
+	bucket struct {
+		tokens    float64
+		updatedAt time.Time
+	}
+)
+
+func PerPeriod(name string, n int, period time.Duration) Limit {
+	return Limit{Name: name, Rate: float64(n) / period.Seconds(), Burst: n}
+}
+
+func ClientKey(r *http.Request) string {
+	if key, ok := auth.FromContext(r.Context()); ok {
+		return "key:" + key.ID
+	}
+	return "ip:" + proxy.ClientIP(r)
+}
+
+func NewLimiter(backend Backend, limit Limit) *Limiter {
+	limiter := &Limiter{backend: backend}
+	limiter.SetLimit(limit)
+	return limiter
+}
+
+func (l *Limiter) SetLimit(limit Limit) {
+	l.limit.Store(&limit)
+}
+
+// Take allows everything while the burst is zero.
+func (l *Limiter) Take(ctx context.Context, client string, n int) (Result, Limit, error) {
+	limit := *l.limit.Load()
+	if limit.Burst <= 0 {
+		return Result{Allowed: true}, limit, nil
+	}
+	result, err := l.backend.Take(ctx, limit.Name+":"+client, limit, n)
+	return result, limit, err
+}
+
+// Allow answers 429 and returns false over the limit.
+func (l *Limiter) Allow(w http.ResponseWriter, r *http.Request, n int) bool {
+	client := ClientKey(r)
+	result, limit, err := l.Take(r.Context(), client, n)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorw("rate limiter unavailable", "key", limit.Name+":"+client, "error", err)
+		return true
+	}
+	if limit.Burst <= 0 {
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(Seconds(result.Reset)))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(Seconds(result.RetryAfter)))
+		problem.Respond(w, r, problem.New(problem.RateLimited, "Too many requests."))
+		return false
+	}
+	return true
+}
+
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Allow(w, r, 1) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (b *bucket) take(limit Limit, now time.Time, n int) Result {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+math.Max(elapsed, 0)*limit.Rate)
+	b.updatedAt = now
+
+	result := Result{Allowed: b.tokens >= float64(n)}
+	if result.Allowed {
+		b.tokens -= float64(n)
+	} else {
+		result.RetryAfter = duration((float64(min(n, limit.Burst)) - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = duration((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return result
+}
+
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func Seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	now := time.Unix(0, 0)
+	backend := NewMemoryBackend()
+	backend.now = func() time.Time { return now }
+	limit := PerPeriod("create", 2, time.Minute)
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{"first request", 0, true, 1},
+		{"second request", 0, true, 0},
+		{"over the limit", 0, false, 0},
+		{"after partial refill", 30 * time.Second, true, 0},
+		{"after full refill", 2 * time.Minute, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			result, err := backend.Take(context.TODO(), "create:127.0.0.1", limit, 1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, result.Allowed)
+			assert.Equal(t, tt.remaining, result.Remaining)
+		})
+	}
+}
+
+func TestMemoryBackendCost(t *testing.T) {
+	now := time.Unix(0, 0)
+	backend := NewMemoryBackend()
+	backend.now = func() time.Time { return now }
+	limit := PerPeriod("create", 3, time.Minute)
+
+	tests := []struct {
+		name      string
+		n         int
+		allowed   bool
+		remaining int
+	}{
+		{"batch of two", 2, true, 1},
+		{"batch over the remaining tokens", 2, false, 1},
+		{"single request", 1, true, 0},
+		{"batch over the burst", 4, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := backend.Take(context.TODO(), "create:127.0.0.1", limit, tt.n)
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, result.Allowed)
+			assert.Equal(t, tt.remaining, result.Remaining)
+		})
+	}
+}
+
+func TestMemoryBackendPrune(t *testing.T) {
+	now := time.Unix(0, 0)
+	backend := NewMemoryBackend()
+	backend.now = func() time.Time { return now }
+	limit := PerPeriod("create", 1, time.Minute)
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := backend.Take(context.TODO(), key, limit, 1)
+		require.NoError(t, err)
+	}
+	assert.Len(t, backend.buckets, 3, "nothing is pruned within the interval")
+
+	now = now.Add(pruneInterval)
+	_, err := backend.Take(context.TODO(), "d", limit, 1)
+	require.NoError(t, err)
+	assert.Len(t, backend.buckets, 1, "refilled buckets are pruned")
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := NewLimiter(NewMemoryBackend(), PerPeriod("redirect", 1, time.Minute)).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		}),
+	)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		status     int
+		retryAfter string
+	}{
+		{"first client request", "10.0.0.1:1234", http.StatusTemporaryRedirect, ""},
+		{"first client over the limit", "10.0.0.1:4321", http.StatusTooManyRequests, "60"},
+		{"second client request", "10.0.0.2:1234", http.StatusTemporaryRedirect, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/abcdefgh", nil)
+			request.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, tt.retryAfter, rec.Header().Get("Retry-After"))
+		})
+	}
+}
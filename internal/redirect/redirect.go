@@ -0,0 +1,201 @@
+package redirect
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Passthrough modes: merge adds the parameters the destination does not set,
+// override replaces the ones it does, ignore drops them all.
+const (
+	PassthroughIgnore   = "ignore"
+	PassthroughMerge    = "merge"
+	PassthroughOverride = "override"
+)
+
+var (
+	ErrInvalidPassthrough = errors.New("passthrough must be ignore, merge or override")
+	ErrUnsafeTarget       = errors.New("template changes the destination host")
+
+	placeholder = regexp.MustCompile(`\{(path|query\.[^{}/?#&=]+)\}`)
+)
+
+const pathPlaceholder = ""
+
+type Request struct {
+	Path  string
+	Query url.Values
+}
+
+func ValidPassthrough(mode string) error {
+	switch mode {
+	case "", PassthroughIgnore, PassthroughMerge, PassthroughOverride:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidPassthrough, mode)
+}
+
+// Target fills {path} and {query.name} in destination, appends a path it does
+// not place and passes on the query parameters it did not consume.
+func Target(destination, mode string, req Request) (string, error) {
+	target, consumed, err := expand(destination, req)
+	if err != nil {
+		return "", err
+	}
+	if !consumed[pathPlaceholder] {
+		target = appendPath(target, req.Path)
+	}
+	if mode != PassthroughMerge && mode != PassthroughOverride {
+		return target, nil
+	}
+
+	incoming := make(url.Values, len(req.Query))
+	for key, values := range req.Query {
+		if !consumed[key] && len(values) > 0 {
+			incoming[key] = values
+		}
+	}
+	if len(incoming) == 0 {
+		return target, nil
+	}
+
+	target, fragment, hasFragment := strings.Cut(target, "#")
+	target, query, _ := strings.Cut(target, "?")
+	var pairs []string
+	present := make(map[string]bool)
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		key := queryKey(pair)
+		if mode == PassthroughOverride && incoming[key] != nil {
+			continue
+		}
+		present[key] = true
+		pairs = append(pairs, pair)
+	}
+
+	keys := make([]string, 0, len(incoming))
+	for key := range incoming {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if present[key] {
+			continue
+		}
+		for _, value := range incoming[key] {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	if len(pairs) > 0 {
+		target += "?" + strings.Join(pairs, "&")
+	}
+	if hasFragment {
+		target += "#" + fragment
+	}
+	return target, nil
+}
+
+// expand only fills placeholders after the host, so a request can never pick
+// where it is sent.
+func expand(destination string, req Request) (string, map[string]bool, error) {
+	consumed := make(map[string]bool)
+	if !strings.Contains(destination, "{") {
+		return destination, consumed, nil
+	}
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Host == "" {
+		return destination, consumed, nil
+	}
+
+	start := authorityEnd(destination)
+	head, tail := destination[:start], destination[start:]
+	queryStart := strings.IndexAny(tail, "?#")
+	if queryStart < 0 {
+		queryStart = len(tail)
+	}
+
+	var buf strings.Builder
+	last := 0
+	for _, match := range placeholder.FindAllStringSubmatchIndex(tail, -1) {
+		buf.WriteString(tail[last:match[0]])
+		name := tail[match[2]:match[3]]
+		inPath := match[0] < queryStart
+		if name == "path" {
+			consumed[pathPlaceholder] = true
+			buf.WriteString(escapePath(req.Path, inPath))
+		} else {
+			key := strings.TrimPrefix(name, "query.")
+			consumed[key] = true
+			if inPath {
+				buf.WriteString(url.PathEscape(req.Query.Get(key)))
+			} else {
+				buf.WriteString(url.QueryEscape(req.Query.Get(key)))
+			}
+		}
+		last = match[1]
+	}
+	buf.WriteString(tail[last:])
+
+	target := head + buf.String()
+	result, err := url.Parse(target)
+	if err != nil {
+		return "", nil, err
+	}
+	if result.Scheme != parsed.Scheme || result.Host != parsed.Host {
+		return "", nil, ErrUnsafeTarget
+	}
+	return target, consumed, nil
+}
+
+func appendPath(target, path string) string {
+	escaped := escapePath(path, true)
+	if escaped == "" {
+		return target
+	}
+	if parsed, err := url.Parse(target); err != nil || parsed.Host == "" {
+		return target
+	}
+	start := authorityEnd(target)
+	end := strings.IndexAny(target[start:], "?#")
+	if end < 0 {
+		end = len(target)
+	} else {
+		end += start
+	}
+	return strings.TrimSuffix(target[:end], "/") + "/" + escaped + target[end:]
+}
+
+func authorityEnd(raw string) int {
+	start := strings.Index(raw, "//") + 2
+	return start + strings.IndexAny(raw[start:]+"/", "/?#")
+}
+
+// escapePath drops empty and dot segments.
+func escapePath(path string, inPath bool) string {
+	if !inPath {
+		return url.QueryEscape(path)
+	}
+	segments := make([]string, 0, strings.Count(path, "/")+1)
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, url.PathEscape(segment))
+	}
+	return strings.Join(segments, "/")
+}
+
+func queryKey(pair string) string {
+	key, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
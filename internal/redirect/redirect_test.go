@@ -0,0 +1,77 @@
+package redirect
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		mode        string
+		path        string
+		query       string
+		want        string
+	}{
+		{"ignore", "https://go.dev/doc?a=1", PassthroughIgnore, "", "utm_source=x", "https://go.dev/doc?a=1"},
+		{"empty mode ignores", "https://go.dev/doc", "", "", "utm_source=x", "https://go.dev/doc"},
+		{"merge without query", "https://go.dev/doc", PassthroughMerge, "", "utm_source=x&utm_medium=mail",
+			"https://go.dev/doc?utm_medium=mail&utm_source=x"},
+		{"merge keeps destination values", "https://go.dev/doc?utm_source=site&a=1", PassthroughMerge, "",
+			"utm_source=x&b=2", "https://go.dev/doc?utm_source=site&a=1&b=2"},
+		{"override replaces destination values", "https://go.dev/doc?utm_source=site&a=1&utm_source=old",
+			PassthroughOverride, "", "utm_source=x", "https://go.dev/doc?a=1&utm_source=x"},
+		{"repeated parameters", "https://go.dev/doc", PassthroughMerge, "", "tag=a&tag=b", "https://go.dev/doc?tag=a&tag=b"},
+		{"fragment stays last", "https://go.dev/doc?a=1#install", PassthroughMerge, "", "b=2",
+			"https://go.dev/doc?a=1&b=2#install"},
+		{"empty query mark", "https://go.dev/doc?", PassthroughMerge, "", "b=2", "https://go.dev/doc?b=2"},
+		{"no incoming parameters", "https://go.dev/doc?a=%7E", PassthroughOverride, "", "", "https://go.dev/doc?a=%7E"},
+		{"escaped keys match", "https://go.dev/doc?utm%5Fsource=site", PassthroughMerge, "", "utm_source=x",
+			"https://go.dev/doc?utm%5Fsource=site"},
+		{"incoming values are escaped", "https://go.dev/doc", PassthroughMerge, "", "q=a+b%26c%3D%23",
+			"https://go.dev/doc?q=a+b%26c%3D%23"},
+		{"query placeholder", "https://go.dev/doc?src={query.ref}", PassthroughIgnore, "", "ref=a%26b=c",
+			"https://go.dev/doc?src=a%26b%3Dc"},
+		{"missing placeholder value", "https://go.dev/doc?src={query.ref}", PassthroughIgnore, "", "",
+			"https://go.dev/doc?src="},
+		{"consumed parameters are not passed", "https://go.dev/doc?src={query.ref}", PassthroughMerge, "",
+			"ref=news&utm_source=x", "https://go.dev/doc?src=news&utm_source=x"},
+		{"path placeholder", "https://go.dev/{path}", PassthroughIgnore, "doc/go 1.22/../x", "",
+			"https://go.dev/doc/go%201.22/x"},
+		{"query placeholder in path", "https://go.dev/user/{query.id}", PassthroughIgnore, "", "id=../../admin",
+			"https://go.dev/user/..%2F..%2Fadmin"},
+		{"path placeholder in query", "https://go.dev/search?q={path}", PassthroughIgnore, "a/b c", "",
+			"https://go.dev/search?q=a%2Fb+c"},
+		{"appended path", "https://docs.example.com", PassthroughIgnore, "api/v2", "",
+			"https://docs.example.com/api/v2"},
+		{"appended below destination path", "https://docs.example.com/base/?lang=en#top", PassthroughMerge, "api/a b",
+			"utm_source=x", "https://docs.example.com/base/api/a%20b?lang=en&utm_source=x#top"},
+		{"placed path is not appended", "https://docs.example.com/{path}/index.html", PassthroughIgnore, "api", "",
+			"https://docs.example.com/api/index.html"},
+		{"dot segments are not appended", "https://docs.example.com/base", PassthroughIgnore, "../..", "",
+			"https://docs.example.com/base"},
+		{"unknown placeholder", "https://go.dev/{name}", PassthroughIgnore, "", "name=x", "https://go.dev/{name}"},
+		{"placeholder in host", "https://{query.host}/", PassthroughIgnore, "", "host=evil.example",
+			"https://{query.host}/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			got, err := Target(tt.destination, tt.mode, Request{Path: tt.path, Query: query})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidPassthrough(t *testing.T) {
+	for _, mode := range []string{"", PassthroughIgnore, PassthroughMerge, PassthroughOverride} {
+		assert.NoError(t, ValidPassthrough(mode))
+	}
+	assert.ErrorIs(t, ValidPassthrough("append"), ErrInvalidPassthrough)
+}
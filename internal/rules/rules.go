@@ -0,0 +1,264 @@
+package rules
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A phone is both mobile and ios or android, bots are nothing else.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+
+	MaxRules    = 32
+	MaxVariants = 16
+)
+
+var (
+	ErrInvalidRule = errors.New("invalid rule")
+
+	devices    = []string{DeviceDesktop, DeviceMobile, DeviceTablet, DeviceBot, DeviceIOS, DeviceAndroid}
+	botMarkers = []string{"bot", "crawl", "spider", "slurp", "facebookexternalhit", "preview"}
+)
+
+type (
+	// Rule matches when every condition that is set holds. "pt" also matches
+	// "pt-BR", Until is excluded.
+	Rule struct {
+		Devices     []string   `json:"devices,omitempty"`
+		Languages   []string   `json:"languages,omitempty"`
+		Countries   []string   `json:"countries,omitempty"`
+		From        *time.Time `json:"from,omitempty"`
+		Until       *time.Time `json:"until,omitempty"`
+		Destination string     `json:"destination,omitempty"`
+		Split       []Variant  `json:"split,omitempty"`
+	}
+	Variant struct {
+		Destination string `json:"destination"`
+		Weight      int    `json:"weight"`
+	}
+
+	// Visitor.Roll is in [0, 1) and picks among Split destinations.
+	Visitor struct {
+		Devices   []string
+		Languages []string
+		Country   string
+		Time      time.Time
+		Roll      float64
+	}
+)
+
+// Match falls back to fallback when no rule matches.
+func Match(rules []Rule, fallback Rule, v Visitor) (destination string, split bool) {
+	rule := fallback
+	for _, candidate := range rules {
+		if candidate.Matches(v) {
+			rule = candidate
+			break
+		}
+	}
+	return rule.pick(v.Roll), len(rule.Split) > 0
+}
+
+func (r Rule) Matches(v Visitor) bool {
+	if len(r.Devices) > 0 && !slices.ContainsFunc(r.Devices, func(device string) bool {
+		return slices.Contains(v.Devices, strings.ToLower(device))
+	}) {
+		return false
+	}
+	if len(r.Languages) > 0 && !slices.ContainsFunc(r.Languages, func(language string) bool {
+		return len(v.Languages) > 0 && languageMatches(language, v.Languages[0])
+	}) {
+		return false
+	}
+	if len(r.Countries) > 0 && !slices.ContainsFunc(r.Countries, func(country string) bool {
+		return strings.EqualFold(country, v.Country)
+	}) {
+		return false
+	}
+	if r.From != nil && v.Time.Before(*r.From) {
+		return false
+	}
+	if r.Until != nil && !v.Time.Before(*r.Until) {
+		return false
+	}
+	return true
+}
+
+func (r Rule) pick(roll float64) string {
+	if len(r.Split) == 0 {
+		return r.Destination
+	}
+	return Pick(r.Split, roll)
+}
+
+func Pick(variants []Variant, roll float64) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	target := int(roll * float64(total))
+	for _, variant := range variants {
+		if target < variant.Weight {
+			return variant.Destination
+		}
+		target -= variant.Weight
+	}
+	return variants[len(variants)-1].Destination
+}
+
+func Roll(seed string) float64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(seed))
+	return float64(binary.BigEndian.Uint64(hash.Sum(nil))>>11) / (1 << 53)
+}
+
+func Destinations(rules []Rule) []string {
+	var destinations []string
+	for _, rule := range rules {
+		if rule.Destination != "" {
+			destinations = append(destinations, rule.Destination)
+		}
+		for _, variant := range rule.Split {
+			destinations = append(destinations, variant.Destination)
+		}
+	}
+	return destinations
+}
+
+// Validate leaves destination URLs to the caller.
+func Validate(rules []Rule) error {
+	if len(rules) > MaxRules {
+		return fmt.Errorf("%w: at most %d rules", ErrInvalidRule, MaxRules)
+	}
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if (r.Destination == "") == (len(r.Split) == 0) {
+		return fmt.Errorf("%w: set either destination or split", ErrInvalidRule)
+	}
+	if len(r.Split) > 0 {
+		if err := ValidateSplit(r.Split); err != nil {
+			return err
+		}
+	}
+	for _, device := range r.Devices {
+		if !slices.Contains(devices, strings.ToLower(device)) {
+			return fmt.Errorf("%w: unknown device %q, expected one of %s", ErrInvalidRule, device,
+				strings.Join(devices, ", "))
+		}
+	}
+	for _, language := range r.Languages {
+		if language == "" || strings.ContainsAny(language, ",; ") {
+			return fmt.Errorf("%w: invalid language %q", ErrInvalidRule, language)
+		}
+	}
+	for _, country := range r.Countries {
+		if len(country) != 2 {
+			return fmt.Errorf("%w: country %q must be a two-letter code", ErrInvalidRule, country)
+		}
+	}
+	if r.From != nil && r.Until != nil && !r.From.Before(*r.Until) {
+		return fmt.Errorf("%w: from must be before until", ErrInvalidRule)
+	}
+	return nil
+}
+
+func ValidateSplit(variants []Variant) error {
+	if len(variants) > MaxVariants {
+		return fmt.Errorf("%w: at most %d split entries", ErrInvalidRule, MaxVariants)
+	}
+	for i, variant := range variants {
+		if variant.Destination == "" || variant.Weight <= 0 {
+			return fmt.Errorf("%w: split entries need a destination and a positive weight", ErrInvalidRule)
+		}
+		if slices.ContainsFunc(variants[:i], func(other Variant) bool { return other.Destination == variant.Destination }) {
+			return fmt.Errorf("%w: duplicate split destination %q", ErrInvalidRule, variant.Destination)
+		}
+	}
+	return nil
+}
+
+func Devices(userAgent string) []string {
+	ua := strings.ToLower(userAgent)
+	if slices.ContainsFunc(botMarkers, func(marker string) bool { return strings.Contains(ua, marker) }) {
+		return []string{DeviceBot}
+	}
+
+	var classes []string
+	ios := strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod")
+	android := strings.Contains(ua, "android")
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || android && !strings.Contains(ua, "mobile"):
+		classes = append(classes, DeviceTablet)
+	case ios || android || strings.Contains(ua, "mobile") || strings.Contains(ua, "windows phone"):
+		classes = append(classes, DeviceMobile)
+	default:
+		classes = append(classes, DeviceDesktop)
+	}
+	if ios {
+		classes = append(classes, DeviceIOS)
+	}
+	if android {
+		classes = append(classes, DeviceAndroid)
+	}
+	return classes
+}
+
+func Languages(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			tags = append(tags, weighted{tag, quality})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	languages := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		languages = append(languages, tag.tag)
+	}
+	return languages
+}
+
+func languageMatches(rule, language string) bool {
+	return strings.EqualFold(rule, language) ||
+		len(language) > len(rule) && language[len(rule)] == '-' && strings.EqualFold(rule, language[:len(rule)])
+}
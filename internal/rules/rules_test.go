@@ -0,0 +1,131 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	iPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	pixel   = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+	galaxy  = "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+	desktop = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+	crawler = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func TestDevices(t *testing.T) {
+	assert.Equal(t, []string{DeviceMobile, DeviceIOS}, Devices(iPhone))
+	assert.Equal(t, []string{DeviceMobile, DeviceAndroid}, Devices(pixel))
+	assert.Equal(t, []string{DeviceTablet, DeviceAndroid}, Devices(galaxy))
+	assert.Equal(t, []string{DeviceDesktop}, Devices(desktop))
+	assert.Equal(t, []string{DeviceBot}, Devices(crawler))
+	assert.Equal(t, []string{DeviceDesktop}, Devices(""))
+}
+
+func TestLanguages(t *testing.T) {
+	assert.Equal(t, []string{"de-CH", "de", "en"}, Languages("en;q=0.5, de-CH, de;q=0.9, *;q=0.1"))
+	assert.Equal(t, []string{"fr"}, Languages("fr, es;q=0, it;q=oops"))
+	assert.Empty(t, Languages(""))
+}
+
+func TestMatch(t *testing.T) {
+	launch := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := launch.AddDate(0, 1, 0)
+	rules := []Rule{
+		{Devices: []string{"IOS"}, Destination: "https://apps.apple.com/app/id1"},
+		{Devices: []string{DeviceAndroid}, Destination: "https://play.google.com/store/apps/details?id=app"},
+		{Countries: []string{"de", "AT"}, Languages: []string{"de"}, Destination: "https://example.de"},
+		{From: &launch, Until: &end, Destination: "https://example.com/launch"},
+		{Devices: []string{DeviceDesktop}, Split: []Variant{
+			{Destination: "https://example.com/a", Weight: 3},
+			{Destination: "https://example.com/b", Weight: 1},
+		}},
+	}
+	before := launch.Add(-time.Hour)
+	fallback := Rule{Destination: "https://example.com"}
+
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{"iphone", Visitor{Devices: Devices(iPhone)}, "https://apps.apple.com/app/id1"},
+		{"android tablet", Visitor{Devices: Devices(galaxy)}, "https://play.google.com/store/apps/details?id=app"},
+		{"german in austria", Visitor{Languages: []string{"de-AT", "en"}, Country: "AT", Time: before},
+			"https://example.de"},
+		{"english in austria", Visitor{Languages: []string{"en", "de"}, Country: "AT", Time: before},
+			"https://example.com"},
+		{"german elsewhere", Visitor{Languages: []string{"de"}, Country: "US", Time: before}, "https://example.com"},
+		{"language prefix only", Visitor{Languages: []string{"dev"}, Country: "DE", Time: before},
+			"https://example.com"},
+		{"launch window start", Visitor{Time: launch}, "https://example.com/launch"},
+		{"launch window end", Visitor{Devices: []string{DeviceBot}, Time: end}, "https://example.com"},
+		{"split first", Visitor{Devices: Devices(desktop), Time: end, Roll: 0.74}, "https://example.com/a"},
+		{"split second", Visitor{Devices: Devices(desktop), Time: end, Roll: 0.75}, "https://example.com/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, split := Match(rules, fallback, tt.visitor)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, strings.HasPrefix(tt.name, "split"), split)
+		})
+	}
+
+	got, split := Match(nil, Rule{Split: []Variant{{Destination: "https://example.com/a", Weight: 1},
+		{Destination: "https://example.com/b", Weight: 1}}}, Visitor{Roll: 0.5})
+	assert.Equal(t, "https://example.com/b", got)
+	assert.True(t, split)
+
+	assert.NoError(t, Validate(rules))
+	assert.Len(t, Destinations(rules), 6)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"no destination", Rule{Devices: []string{DeviceMobile}}},
+		{"destination and split", Rule{Destination: "https://a.example", Split: []Variant{{"https://b.example", 1}}}},
+		{"zero weight", Rule{Split: []Variant{{"https://b.example", 0}}}},
+		{"duplicate variant", Rule{Split: []Variant{{"https://b.example", 1}, {"https://b.example", 2}}}},
+		{"unknown device", Rule{Devices: []string{"watch"}, Destination: "https://a.example"}},
+		{"invalid language", Rule{Languages: []string{"de, en"}, Destination: "https://a.example"}},
+		{"invalid country", Rule{Countries: []string{"DEU"}, Destination: "https://a.example"}},
+		{"empty window", Rule{From: &now, Until: &now, Destination: "https://a.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, Validate([]Rule{tt.rule}), ErrInvalidRule)
+		})
+	}
+	assert.ErrorIs(t, Validate(make([]Rule, MaxRules+1)), ErrInvalidRule)
+}
+
+func TestPick(t *testing.T) {
+	variants := []Variant{{"https://example.com/a", 1}, {"https://example.com/b", 1}}
+	assert.Equal(t, "https://example.com/a", Pick(variants, 0))
+	assert.Equal(t, "https://example.com/b", Pick(variants, 0.5))
+	assert.Equal(t, "https://example.com/b", Pick(variants, 0.99))
+	assert.Equal(t, "", Pick(nil, 0.5))
+	assert.ErrorIs(t, ValidateSplit(make([]Variant, MaxVariants+1)), ErrInvalidRule)
+}
+
+func TestRoll(t *testing.T) {
+	assert.Equal(t, Roll("visitor/docs"), Roll("visitor/docs"))
+	assert.NotEqual(t, Roll("visitor/docs"), Roll("visitor/blog"))
+
+	picked := map[string]int{}
+	variants := []Variant{{"https://example.com/a", 3}, {"https://example.com/b", 1}}
+	for i := range 4000 {
+		roll := Roll(fmt.Sprintf("visitor-%d/docs", i))
+		assert.True(t, roll >= 0 && roll < 1)
+		picked[Pick(variants, roll)]++
+	}
+	assert.InDelta(t, 3000, picked["https://example.com/a"], 150)
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrQuotaExceeded  = errors.New("api key quota exceeded")
+)
+
+type (
+	APIKeyStore interface {
+		CreateAPIKey(ctx context.Context, key APIKey) error
+		FindAPIKey(ctx context.Context, hash string) (APIKey, error)
+		ListAPIKeys(ctx context.Context) ([]APIKey, error)
+		RevokeAPIKey(ctx context.Context, id string) error
+		// UseAPIKeyQuota refunds for a negative n.
+		UseAPIKeyQuota(ctx context.Context, id string, n int) error
+	}
+	APIKey struct {
+		ID        string     `json:"id"`
+		Name      string     `json:"name"`
+		Hash      string     `json:"hash"`
+		Scopes    []string   `json:"scopes"`
+		Quota     int        `json:"quota"`
+		Used      int        `json:"used"`
+		CreatedAt time.Time  `json:"created_at"`
+		RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	}
+)
+
+func (key APIKey) HasScope(scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (key APIKey) Revoked() bool {
+	return key.RevokedAt != nil
+}
+
+func (key APIKey) HasQuota(n int) bool {
+	return key.Quota == 0 || key.Used+n <= key.Quota
+}
+
+func (store *MemoryStore) CreateAPIKey(_ context.Context, key APIKey) error {
+	return store.updateAPIKeys(func(keys []APIKey) ([]APIKey, error) {
+		return append(keys, key), nil
+	})
+}
+
+func (store *MemoryStore) FindAPIKey(_ context.Context, hash string) (APIKey, error) {
+	store.keysMu.Lock()
+	defer store.keysMu.Unlock()
+
+	if err := store.refreshAPIKeys(); err != nil {
+		return APIKey{}, err
+	}
+	for _, key := range store.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (store *MemoryStore) ListAPIKeys(_ context.Context) ([]APIKey, error) {
+	store.keysMu.Lock()
+	defer store.keysMu.Unlock()
+
+	if err := store.refreshAPIKeys(); err != nil {
+		return nil, err
+	}
+	return append([]APIKey(nil), store.keys...), nil
+}
+
+func (store *MemoryStore) RevokeAPIKey(_ context.Context, id string) error {
+	return store.updateAPIKeys(func(keys []APIKey) ([]APIKey, error) {
+		for i := range keys {
+			if keys[i].ID == id && !keys[i].Revoked() {
+				now := time.Now().UTC()
+				keys[i].RevokedAt = &now
+				return keys, nil
+			}
+		}
+		return nil, ErrAPIKeyNotFound
+	})
+}
+
+func (store *MemoryStore) UseAPIKeyQuota(_ context.Context, id string, n int) error {
+	return store.updateAPIKeys(func(keys []APIKey) ([]APIKey, error) {
+		for i := range keys {
+			if keys[i].ID != id {
+				continue
+			}
+			if !keys[i].HasQuota(n) {
+				return nil, ErrQuotaExceeded
+			}
+			keys[i].Used += n
+			return keys, nil
+		}
+		return nil, ErrAPIKeyNotFound
+	})
+}
+
+// updateAPIKeys rereads the key file under a file lock, the keys command
+// writes it too.
+func (store *MemoryStore) updateAPIKeys(change func(keys []APIKey) ([]APIKey, error)) error {
+	store.keysMu.Lock()
+	defer store.keysMu.Unlock()
+
+	path := store.Config.Get().APIKeysPath
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := readAPIKeys(path)
+	if err != nil {
+		return err
+	}
+	if keys, err = change(keys); err != nil {
+		return err
+	}
+	if err := writeJSONLines(path, keys, 0600); err != nil {
+		return err
+	}
+	store.keys = keys
+	store.keysStat, _ = os.Stat(path)
+	return nil
+}
+
+// refreshAPIKeys treats a replaced file as changed even with the same size and
+// mtime.
+func (store *MemoryStore) refreshAPIKeys() error {
+	path := store.Config.Get().APIKeysPath
+	stat, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if stat == nil && store.keysStat == nil || stat != nil && store.keysStat != nil && os.SameFile(stat, store.keysStat) &&
+		stat.ModTime().Equal(store.keysStat.ModTime()) && stat.Size() == store.keysStat.Size() {
+		return nil
+	}
+	keys, err := readAPIKeys(path)
+	if err != nil {
+		return err
+	}
+	store.keys, store.keysStat = keys, stat
+	return nil
+}
+
+func (store *MemoryStore) loadAPIKeys() error {
+	store.keysMu.Lock()
+	defer store.keysMu.Unlock()
+
+	return store.refreshAPIKeys()
+}
+
+func readAPIKeys(path string) ([]APIKey, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys []APIKey
+	decoder := json.NewDecoder(file)
+	for {
+		var key APIKey
+		if err := decoder.Decode(&key); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (store *DatabaseStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, name, hash, scopes, quota, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := store.DB.ExecContext(ctx, query,
+		key.ID, key.Name, key.Hash, strings.Join(key.Scopes, ","), key.Quota, key.CreatedAt)
+	return err
+}
+
+func (store *DatabaseStore) FindAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	query := `
+		SELECT id, name, hash, scopes, quota, used, created_at, revoked_at
+		FROM api_keys WHERE hash = $1
+	`
+	key, err := scanAPIKey(store.DB.QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (store *DatabaseStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	query := `
+		SELECT id, name, hash, scopes, quota, used, created_at, revoked_at
+		FROM api_keys ORDER BY created_at
+	`
+	rows, err := store.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (store *DatabaseStore) RevokeAPIKey(ctx context.Context, id string) error {
+	query := `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`
+	result, err := store.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (store *DatabaseStore) UseAPIKeyQuota(ctx context.Context, id string, n int) error {
+	query := `
+		UPDATE api_keys SET used = used + $2
+		WHERE id = $1 AND (quota = 0 OR used + $2 <= quota)
+	`
+	result, err := store.DB.ExecContext(ctx, query, id, n)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Hash, &scopes, &key.Quota, &key.Used, &key.CreatedAt, &revokedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeysSharedFile(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	server := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, server.Initialize(context.TODO()))
+	cli := &MemoryStore{Config: config.New(*cfg)}
+
+	require.NoError(t, server.CreateAPIKey(context.TODO(), APIKey{ID: "ci", Hash: "ci-hash", Quota: 3}))
+	require.NoError(t, cli.CreateAPIKey(context.TODO(), APIKey{ID: "bot", Hash: "bot-hash"}))
+
+	key, err := server.FindAPIKey(context.TODO(), "bot-hash")
+	require.NoError(t, err)
+	assert.Equal(t, "bot", key.ID)
+
+	require.NoError(t, server.UseAPIKeyQuota(context.TODO(), "ci", 2))
+	assert.ErrorIs(t, server.UseAPIKeyQuota(context.TODO(), "ci", 2), ErrQuotaExceeded)
+	require.NoError(t, cli.RevokeAPIKey(context.TODO(), "bot"))
+	require.NoError(t, server.UseAPIKeyQuota(context.TODO(), "ci", 1))
+
+	keys, err := cli.ListAPIKeys(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, 3, keys[0].Used)
+	assert.True(t, keys[1].Revoked())
+	key, err = server.FindAPIKey(context.TODO(), "bot-hash")
+	require.NoError(t, err)
+	assert.True(t, key.Revoked())
+}
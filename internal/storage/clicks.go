@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// AllVariants counts every redirect of a link.
+const AllVariants = ""
+
+const clickFlushInterval = time.Second
+
+type (
+	// ClickStore counts redirects per variant, a variant is a destination.
+	ClickStore interface {
+		RecordClick(ctx context.Context, domain, key, variant string) error
+		ListClicks(ctx context.Context, domain, key string) (map[string]int64, error)
+		ImportClicks(ctx context.Context, clicks []VariantClicks) error
+	}
+	// VariantClicks is one line of the click log, lines add up on load.
+	VariantClicks struct {
+		Domain   string `json:"domain,omitempty"`
+		ShortURL string `json:"short_url"`
+		Variant  string `json:"variant"`
+		Clicks   int64  `json:"clicks"`
+	}
+	clickKey struct {
+		domain, shortURL, variant string
+	}
+)
+
+// RecordClick leaves the click log to a later flush so redirects never wait
+// for the file.
+func (store *MemoryStore) RecordClick(ctx context.Context, domain, key, variant string) error {
+	_, span := tracing.Start(ctx, "MemoryStore.RecordClick")
+	defer span.End()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.clicks == nil {
+		store.clicks = make(map[clickKey]int64)
+	}
+	store.clicks[clickKey{domain, key, variant}]++
+	if store.pendingClicks == nil {
+		store.pendingClicks = make(map[clickKey]int64)
+		store.flushClicksLater(logger.FromContext(ctx))
+	}
+	store.pendingClicks[clickKey{domain, key, variant}]++
+	return nil
+}
+
+func (store *MemoryStore) ImportClicks(ctx context.Context, clicks []VariantClicks) error {
+	_, span := tracing.Start(ctx, "MemoryStore.ImportClicks")
+	defer span.End()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.clicks == nil {
+		store.clicks = make(map[clickKey]int64)
+	}
+	if store.pendingClicks == nil {
+		store.pendingClicks = make(map[clickKey]int64)
+		store.flushClicksLater(logger.FromContext(ctx))
+	}
+	for _, line := range clicks {
+		store.clicks[clickKey{line.Domain, line.ShortURL, line.Variant}] += line.Clicks
+		store.pendingClicks[clickKey{line.Domain, line.ShortURL, line.Variant}] += line.Clicks
+	}
+	return nil
+}
+
+func FlushClicks(store StoreHandler) error {
+	switch store := store.(type) {
+	case *MemoryStore:
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.appendClicks()
+	case *MigratingStore:
+		return errors.Join(FlushClicks(store.Old), FlushClicks(store.New))
+	}
+	return nil
+}
+
+func (store *MemoryStore) flushClicksLater(log *zap.SugaredLogger) {
+	time.AfterFunc(clickFlushInterval, func() {
+		if err := FlushClicks(store); err != nil {
+			log.Errorw("write click log failed", "error", err)
+			store.flushClicksLater(log)
+		}
+	})
+}
+
+// appendClicks expects the caller to hold the lock.
+func (store *MemoryStore) appendClicks() error {
+	if len(store.pendingClicks) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(store.Config.Get().ClickLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for k, clicks := range store.pendingClicks {
+		line := VariantClicks{Domain: k.domain, ShortURL: k.shortURL, Variant: k.variant, Clicks: clicks}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		delete(store.pendingClicks, k)
+	}
+	store.pendingClicks = nil
+	return nil
+}
+
+func (store *MemoryStore) ListClicks(ctx context.Context, domain, key string) (map[string]int64, error) {
+	_, span := tracing.Start(ctx, "MemoryStore.ListClicks")
+	defer span.End()
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	result := make(map[string]int64)
+	for k, clicks := range store.clicks {
+		if k.domain == domain && k.shortURL == key {
+			result[k.variant] = clicks
+		}
+	}
+	return result, nil
+}
+
+func (store *MemoryStore) loadClicks() error {
+	file, err := os.Open(store.Config.Get().ClickLogPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	store.clicks = make(map[clickKey]int64)
+	decoder := json.NewDecoder(file)
+	for {
+		var line VariantClicks
+		if err := decoder.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		store.clicks[clickKey{line.Domain, line.ShortURL, line.Variant}] += line.Clicks
+	}
+	return nil
+}
+
+// dropClicks expects the caller to hold the lock.
+func (store *MemoryStore) dropClicks(domain, key string) error {
+	store.pendingClicks = nil
+	var lines []VariantClicks
+	for k, clicks := range store.clicks {
+		if k.domain == domain && k.shortURL == key {
+			delete(store.clicks, k)
+			continue
+		}
+		lines = append(lines, VariantClicks{Domain: k.domain, ShortURL: k.shortURL, Variant: k.variant, Clicks: clicks})
+	}
+	return writeJSONLines(store.Config.Get().ClickLogPath, lines, 0600)
+}
+
+func (store *DatabaseStore) RecordClick(ctx context.Context, domain, key, variant string) (err error) {
+	query := `
+		INSERT INTO url_clicks (domain, short_url, variant, clicks) VALUES ($1, $2, $3, 1)
+		ON CONFLICT (domain, short_url, variant) DO UPDATE SET clicks = url_clicks.clicks + 1
+	`
+	ctx, span := tracing.StartDB(ctx, "INSERT", query)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = store.DB.ExecContext(ctx, query, domain, key, variant)
+	return err
+}
+
+func (store *DatabaseStore) ListClicks(ctx context.Context, domain, key string) (_ map[string]int64, err error) {
+	query := `SELECT variant, clicks FROM url_clicks WHERE domain = $1 AND short_url = $2`
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query, domain, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+	for rows.Next() {
+		var variant string
+		var clicks int64
+		if err = rows.Scan(&variant, &clicks); err != nil {
+			return nil, err
+		}
+		result[variant] = clicks
+	}
+	return result, rows.Err()
+}
+
+func (store *DatabaseStore) ImportClicks(ctx context.Context, clicks []VariantClicks) (err error) {
+	ctx, span := tracing.Start(ctx, "DatabaseStore.ImportClicks")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO url_clicks (domain, short_url, variant, clicks) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (domain, short_url, variant) DO UPDATE SET clicks = url_clicks.clicks + EXCLUDED.clicks
+	`
+	for _, line := range clicks {
+		if _, err = tx.ExecContext(ctx, query, line.Domain, line.ShortURL, line.Variant, line.Clicks); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreClickLog(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	store := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, store.Initialize(context.TODO()))
+	for _, key := range []string{"a", "b"} {
+		_, err := store.Save(context.TODO(), URLStore{ShortURL: key, OriginalURL: "https://go.dev/" + key})
+		require.NoError(t, err)
+	}
+	for _, key := range []string{"a", "a", "b"} {
+		require.NoError(t, store.RecordClick(context.TODO(), "", key, AllVariants))
+	}
+	require.NoError(t, store.RecordClick(context.TODO(), "", "a", "https://go.dev/x"))
+
+	clicks, err := store.ListClicks(context.TODO(), "", "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{AllVariants: 2, "https://go.dev/x": 1}, clicks)
+	_, err = os.Stat(cfg.ClickLogPath)
+	assert.ErrorIs(t, err, os.ErrNotExist, "clicks are written by a later flush")
+
+	require.NoError(t, FlushClicks(store))
+	reopened := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, reopened.Initialize(context.TODO()))
+	clicks, err = reopened.ListClicks(context.TODO(), "", "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{AllVariants: 2, "https://go.dev/x": 1}, clicks)
+
+	require.NoError(t, store.RecordClick(context.TODO(), "", "b", AllVariants))
+	require.NoError(t, store.DeleteURL(context.TODO(), "", "a"))
+	require.NoError(t, FlushClicks(store))
+	reopened = &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, reopened.Initialize(context.TODO()))
+	clicks, err = reopened.ListClicks(context.TODO(), "", "b")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{AllVariants: 2}, clicks)
+	clicks, err = reopened.ListClicks(context.TODO(), "", "a")
+	require.NoError(t, err)
+	assert.Empty(t, clicks)
+}
@@ -3,82 +3,296 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
 	"github.com/alexch365/go-url-shortener/internal/util"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
-var schema = `
+var migrations = []string{
+	`
 	CREATE TABLE IF NOT EXISTS urls (
 		id serial PRIMARY KEY,
 		short_url TEXT NOT NULL,
 		original_url TEXT NOT NULL
 	);
 	CREATE UNIQUE INDEX IF NOT EXISTS urls_original_url ON urls(original_url);
-`
+	`,
+	`
+	CREATE TABLE api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		quota INTEGER NOT NULL DEFAULT 0,
+		used INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		revoked_at TIMESTAMPTZ
+	);
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN user_id TEXT;
+	ALTER TABLE urls ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT false;
+	CREATE INDEX urls_user_id ON urls(user_id);
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN is_disabled BOOLEAN NOT NULL DEFAULT false;
+	`,
+	`
+	CREATE TABLE url_events (
+		id bigserial PRIMARY KEY,
+		type TEXT NOT NULL,
+		short_url TEXT NOT NULL,
+		user_id TEXT,
+		actor TEXT NOT NULL,
+		field TEXT,
+		old_value TEXT,
+		new_value TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX url_events_short_url ON url_events(short_url, created_at);
+	CREATE INDEX url_events_user_id ON url_events(user_id, created_at);
+	CREATE INDEX url_events_created_at ON url_events(created_at);
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	CREATE UNIQUE INDEX urls_short_url ON urls(short_url);
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+	DROP INDEX urls_short_url;
+	DROP INDEX urls_original_url;
+	CREATE UNIQUE INDEX urls_domain_short_url ON urls(domain, short_url);
+	CREATE UNIQUE INDEX urls_domain_original_url ON urls(domain, original_url);
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN passthrough TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN is_prefix BOOLEAN NOT NULL DEFAULT false;
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN rules JSONB NOT NULL DEFAULT '[]';
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN split JSONB NOT NULL DEFAULT '[]';
+	CREATE TABLE url_clicks (
+		domain TEXT NOT NULL,
+		short_url TEXT NOT NULL,
+		variant TEXT NOT NULL,
+		clicks BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (domain, short_url, variant)
+	);
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+	ALTER TABLE urls ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE urls ADD COLUMN clicks INTEGER NOT NULL DEFAULT 0;
+	`,
+	`
+	ALTER TABLE urls ADD COLUMN title TEXT NOT NULL DEFAULT '';
+	ALTER TABLE urls ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE urls ADD COLUMN tags JSONB NOT NULL DEFAULT '[]';
+	CREATE INDEX urls_tags ON urls USING GIN (tags);
+	`,
+	`
+	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+	ALTER TABLE urls ADD COLUMN search tsvector
+		GENERATED ALWAYS AS (to_tsvector('simple', title) || jsonb_to_tsvector('simple', tags, '["string"]')) STORED;
+	CREATE INDEX urls_search ON urls USING GIN (search);
+	CREATE INDEX urls_original_url_trgm ON urls USING GIN (lower(original_url) gin_trgm_ops);
+	CREATE INDEX urls_user_id_created_at ON urls(user_id, created_at, domain, short_url);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	`,
+	`
+	ALTER TABLE url_events ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+	DROP INDEX url_events_short_url;
+	CREATE INDEX url_events_domain_short_url ON url_events(domain, short_url, created_at);
+	CREATE INDEX url_events_domain_created_at ON url_events(domain, created_at);
+	`,
+}
+
+const urlColumns = `id, domain, short_url, original_url, COALESCE(user_id, ''), is_deleted, is_disabled, passthrough, is_prefix,
+	rules, split, password_hash, max_clicks, clicks, title, description, tags, created_at`
+
+const (
+	uniqueViolation = "23505"
+	shortURLIndex   = "urls_domain_short_url"
+)
 
 type DatabaseStore struct {
-	DB *sql.DB
+	Config *config.Source
+	DB     *sql.DB
 }
 
+// ConflictError holds the link that already has the original URL.
 type ConflictError struct {
-	ShortURL string
+	URL URLStore
 }
 
-func (store *DatabaseStore) Initialize() error {
+func (store *DatabaseStore) Initialize(ctx context.Context) error {
 	var err error
-	store.DB, err = sql.Open("pgx", config.Current.DatabaseDSN)
+	store.DB, err = sql.Open("pgx", store.Config.Get().DatabaseDSN)
+	if err != nil {
+		return err
+	}
+
+	return store.migrate(ctx)
+}
+
+func (store *DatabaseStore) CheckHealth(ctx context.Context) []ComponentHealth {
+	return []ComponentHealth{
+		checkComponent(ctx, "database", store.DB.PingContext),
+		checkComponent(ctx, "migrations", func(ctx context.Context) error {
+			version, err := store.schemaVersion(ctx)
+			if err != nil {
+				return err
+			}
+			if version != len(migrations) {
+				return fmt.Errorf("schema version %d, expected %d", version, len(migrations))
+			}
+			return nil
+		}),
+	}
+}
+
+func (store *DatabaseStore) migrate(ctx context.Context) error {
+	_, err := store.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY)`)
 	if err != nil {
 		return err
 	}
 
-	_, err = store.DB.Exec(schema)
+	version, err := store.schemaVersion(ctx)
 	if err != nil {
 		return err
 	}
 
+	for i := version; i < len(migrations); i++ {
+		tx, err := store.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, migrations[i]); err == nil {
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		logger.FromContext(ctx).Infow("migration applied", "version", i+1)
+	}
 	return nil
 }
 
-func (store *DatabaseStore) Save(ctx context.Context, originalURL string) (string, error) {
-	shortURL := util.RandomString(8)
+func (store *DatabaseStore) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := store.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+func (store *DatabaseStore) Save(ctx context.Context, item URLStore) (URLStore, error) {
+	shortURL := item.ShortURL
+	if shortURL == "" {
+		shortURL = util.RandomString(8)
+	}
 	query := `
-		INSERT INTO urls (short_url, original_url) VALUES ($1, $2)
-		ON CONFLICT (original_url) DO UPDATE
+		INSERT INTO urls (domain, short_url, original_url, user_id, passthrough, is_prefix, rules, split,
+			password_hash, max_clicks, title, description, tags)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (domain, original_url) DO UPDATE
 		SET original_url = EXCLUDED.original_url
-		RETURNING short_url;
-	`
-	var existingShortURL string
-	err := store.DB.QueryRowContext(ctx, query, shortURL, originalURL).Scan(&existingShortURL)
+		RETURNING ` + urlColumns
+	ruleSet, err := encodeList(item.Rules)
+	if err != nil {
+		return URLStore{}, err
+	}
+	split, err := encodeList(item.Split)
+	if err != nil {
+		return URLStore{}, err
+	}
+	tags, err := encodeList(item.Tags)
+	if err != nil {
+		return URLStore{}, err
+	}
+	ctx, span := tracing.StartDB(ctx, "INSERT", query)
+	saved, err := scanURL(store.DB.QueryRowContext(ctx, query,
+		item.Domain, shortURL, item.OriginalURL, item.UserID, item.Passthrough, item.Prefix, ruleSet, split,
+		item.PasswordHash, item.MaxClicks, item.Title, item.Description, tags))
+	tracing.End(span, err)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == shortURLIndex {
+		return URLStore{}, ErrCodeTaken
+	}
 	if err != nil {
-		return "", err
+		return URLStore{}, err
 	}
 
-	if existingShortURL != shortURL {
-		return "", ConflictError{ShortURL: config.Current.BaseURL + "/" + existingShortURL}
+	if saved.ShortURL != shortURL {
+		logger.FromContext(ctx).Debugw("original url already stored", "url", item.OriginalURL, "short_url", saved.ShortURL)
+		return URLStore{}, ConflictError{URL: saved}
 	}
-	return config.Current.BaseURL + "/" + shortURL, nil
+	return saved, nil
 }
 
-func (store *DatabaseStore) SaveBatch(ctx context.Context, urlStore *[]URLStore) ([]URLStore, error) {
+func (store *DatabaseStore) SaveBatch(ctx context.Context, urlStore *[]URLStore) (_ []URLStore, err error) {
+	ctx, span := tracing.Start(ctx, "DatabaseStore.SaveBatch")
+	defer func() { tracing.End(span, err) }()
+
 	tx, err := store.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
+	query := `
+		INSERT INTO urls (domain, short_url, original_url, user_id, passthrough, is_prefix, title, description, tags,
+			created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10)
+	`
 	var resultURLs []URLStore
+	saved := make(map[[2]string]string, len(*urlStore))
 	for _, item := range *urlStore {
+		if shortURL, ok := saved[[2]string{item.Domain, item.OriginalURL}]; ok {
+			item.ShortURL = shortURL
+			resultURLs = append(resultURLs, item)
+			continue
+		}
 		item.ShortURL = util.RandomString(8)
-		resultItem := item
-		resultItem.ShortURL = config.Current.BaseURL + "/" + item.ShortURL
-		resultURLs = append(resultURLs, resultItem)
+		item.CreatedAt = time.Now().UTC()
+		resultURLs = append(resultURLs, item)
+		saved[[2]string{item.Domain, item.OriginalURL}] = item.ShortURL
+		tags, err := encodeList(item.Tags)
+		if err != nil {
+			return nil, err
+		}
 
-		_, err := tx.ExecContext(ctx, `INSERT INTO urls (short_url, original_url) VALUES ($1, $2)`,
-			item.ShortURL, item.OriginalURL)
+		queryCtx, querySpan := tracing.StartDB(ctx, "INSERT", query)
+		_, err = tx.ExecContext(queryCtx, query,
+			item.Domain, item.ShortURL, item.OriginalURL, item.UserID, item.Passthrough, item.Prefix,
+			item.Title, item.Description, tags, item.CreatedAt)
+		tracing.End(querySpan, err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName != shortURLIndex {
+			tx.Rollback()
+			return nil, store.originalConflict(ctx, item.Domain, item.OriginalURL, err)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -88,22 +302,364 @@ func (store *DatabaseStore) SaveBatch(ctx context.Context, urlStore *[]URLStore)
 	if err != nil {
 		return nil, err
 	}
+	logger.FromContext(ctx).Debugw("batch saved", "size", len(resultURLs))
 	return resultURLs, nil
 }
 
-func (store *DatabaseStore) Get(ctx context.Context, key string) (string, error) {
-	query := `SELECT original_url FROM urls WHERE short_url = $1`
-	var originalURL string
-	err := store.DB.QueryRowContext(ctx, query, key).Scan(&originalURL)
+func (store *DatabaseStore) Get(ctx context.Context, domain, key string) (URLStore, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE domain = $1 AND short_url = $2`
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	item, err := scanURL(store.DB.QueryRowContext(ctx, query, domain, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", fmt.Errorf("short URL not found: %s", key)
+			return URLStore{}, fmt.Errorf("%w: %s", ErrURLNotFound, key)
+		}
+		return URLStore{}, err
+	}
+	if item.DeletedFlag {
+		return URLStore{}, ErrURLDeleted
+	}
+	if item.Disabled {
+		return URLStore{}, ErrURLDisabled
+	}
+	return item, nil
+}
+
+func (store *DatabaseStore) UseClick(ctx context.Context, domain, key string) (_ URLStore, err error) {
+	query := `
+		UPDATE urls SET clicks = clicks + 1, is_disabled = max_clicks > 0 AND clicks + 1 >= max_clicks
+		WHERE domain = $1 AND short_url = $2 AND NOT is_deleted AND NOT is_disabled
+			AND (max_clicks = 0 OR clicks < max_clicks)
+		RETURNING ` + urlColumns
+	ctx, span := tracing.StartDB(ctx, "UPDATE", query)
+	defer func() { tracing.End(span, err) }()
+
+	item, err := scanURL(store.DB.QueryRowContext(ctx, query, domain, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLStore{}, ErrURLDisabled
+	}
+	return item, err
+}
+
+func (store *DatabaseStore) GetUserURLs(ctx context.Context, userID string, filter URLFilter) (_ URLPage, err error) {
+	c, err := filter.decodeCursor()
+	if err != nil {
+		return URLPage{}, err
+	}
+	sortColumn, direction, compare := "u.created_at", "ASC", ">"
+	if filter.sortField() == SortClicks {
+		sortColumn = "COALESCE(c.clicks, 0)"
+	}
+	if filter.Desc {
+		direction, compare = "DESC", "<"
+	}
+	args := []any{userID, filter.Tag, filter.Query, "%" + escapeLike(strings.ToLower(filter.Query)) + "%"}
+	query := `
+		SELECT u.domain, u.short_url, u.original_url, u.split, u.title, u.description, u.tags, u.created_at,
+			COALESCE(c.clicks, 0)
+		FROM urls u
+		LEFT JOIN url_clicks c ON c.domain = u.domain AND c.short_url = u.short_url AND c.variant = ''
+		WHERE u.user_id = $1 AND NOT u.is_deleted AND ($2 = '' OR u.tags @> jsonb_build_array($2::text))
+			AND ($3 = '' OR u.search @@ plainto_tsquery('simple', $3) OR lower(u.original_url) LIKE $4)
+	`
+	if c != nil {
+		var value any = c.CreatedAt
+		if filter.sortField() == SortClicks {
+			value = c.Clicks
+		}
+		args = append(args, value, c.Domain, c.ShortURL)
+		query += fmt.Sprintf(" AND (%s, u.domain, u.short_url) %s ($5, $6, $7)", sortColumn, compare)
+	}
+	query += fmt.Sprintf(" ORDER BY %[1]s %[2]s, u.domain %[2]s, u.short_url %[2]s", sortColumn, direction)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return URLPage{}, err
+	}
+	defer rows.Close()
+
+	var result []URLStore
+	for rows.Next() {
+		var item URLStore
+		var split, tags []byte
+		if err = rows.Scan(&item.Domain, &item.ShortURL, &item.OriginalURL, &split, &item.Title, &item.Description,
+			&tags, &item.CreatedAt, &item.TotalClicks); err != nil {
+			return URLPage{}, err
+		}
+		if err = json.Unmarshal(split, &item.Split); err != nil {
+			return URLPage{}, err
+		}
+		if err = json.Unmarshal(tags, &item.Tags); err != nil {
+			return URLPage{}, err
+		}
+		result = append(result, item)
+	}
+	if err = rows.Err(); err != nil {
+		return URLPage{}, err
+	}
+	if filter.Limit <= 0 || len(result) <= filter.Limit {
+		return URLPage{URLs: result}, nil
+	}
+	result = result[:filter.Limit]
+	return URLPage{URLs: result, NextCursor: filter.encodeCursor(result[len(result)-1])}, nil
+}
+
+func (store *DatabaseStore) DeleteURLs(ctx context.Context, userID string, keys []string) (_ []URLStore, err error) {
+	query := `
+		UPDATE urls SET is_deleted = true
+		WHERE user_id = $1 AND short_url = ANY($2) AND NOT is_deleted
+		RETURNING ` + urlColumns
+	ctx, span := tracing.StartDB(ctx, "UPDATE", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query, userID, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted []URLStore
+	for rows.Next() {
+		item, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, item)
+	}
+	logger.FromContext(ctx).Debugw("urls deleted", "user_id", userID, "count", len(deleted))
+	return deleted, rows.Err()
+}
+
+func (store *DatabaseStore) GetURL(ctx context.Context, domain, key string) (_ URLStore, err error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE domain = $1 AND short_url = $2`
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	item, err := scanURL(store.DB.QueryRowContext(ctx, query, domain, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLStore{}, ErrURLNotFound
+	}
+	return item, err
+}
+
+func (store *DatabaseStore) FindURLs(ctx context.Context, originalURL string) (_ []URLStore, err error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE original_url = $1 ORDER BY id`
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query, originalURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []URLStore
+	for rows.Next() {
+		item, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func (store *DatabaseStore) UpdateURL(ctx context.Context, domain, key string, update URLUpdate) (_ URLStore, err error) {
+	query := `
+		UPDATE urls SET
+			original_url = COALESCE($3, original_url),
+			user_id = CASE WHEN $4::text IS NULL THEN user_id ELSE NULLIF($4, '') END,
+			is_disabled = COALESCE($5, is_disabled),
+			passthrough = COALESCE($6, passthrough),
+			is_prefix = COALESCE($7, is_prefix),
+			rules = COALESCE($8::jsonb, rules),
+			split = COALESCE($9::jsonb, split),
+			password_hash = COALESCE($10, password_hash),
+			max_clicks = COALESCE($11, max_clicks),
+			clicks = COALESCE($12, clicks),
+			title = COALESCE($13, title),
+			description = COALESCE($14, description),
+			tags = COALESCE($15::jsonb, tags),
+			is_deleted = COALESCE($16, is_deleted)
+		WHERE domain = $1 AND short_url = $2
+		RETURNING ` + urlColumns
+	ctx, span := tracing.StartDB(ctx, "UPDATE", query)
+	defer func() { tracing.End(span, err) }()
+
+	var ruleSet, split, tags *string
+	if update.Rules != nil {
+		encoded, err := encodeList(*update.Rules)
+		if err != nil {
+			return URLStore{}, err
+		}
+		ruleSet = &encoded
+	}
+	if update.Split != nil {
+		encoded, err := encodeList(*update.Split)
+		if err != nil {
+			return URLStore{}, err
+		}
+		split = &encoded
+	}
+	if update.Tags != nil {
+		encoded, err := encodeList(*update.Tags)
+		if err != nil {
+			return URLStore{}, err
+		}
+		tags = &encoded
+	}
+	item, err := scanURL(store.DB.QueryRowContext(ctx, query,
+		domain, key, update.OriginalURL, update.UserID, update.Disabled, update.Passthrough,
+		update.Prefix, ruleSet, split, update.PasswordHash, update.MaxClicks, update.Clicks,
+		update.Title, update.Description, tags, update.Deleted))
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLStore{}, ErrURLNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && update.OriginalURL != nil {
+		return URLStore{}, store.originalConflict(ctx, domain, *update.OriginalURL, err)
+	}
+	return item, err
+}
+
+func (store *DatabaseStore) originalConflict(ctx context.Context, domain, originalURL string, err error) error {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE domain = $1 AND original_url = $2`
+	existing, lookupErr := scanURL(store.DB.QueryRowContext(ctx, query, domain, originalURL))
+	if lookupErr != nil {
+		return err
+	}
+	return ConflictError{URL: existing}
+}
+
+func (store *DatabaseStore) DeleteURL(ctx context.Context, domain, key string) error {
+	query := `
+		WITH clicks AS (DELETE FROM url_clicks WHERE domain = $1 AND short_url = $2)
+		DELETE FROM urls WHERE domain = $1 AND short_url = $2
+	`
+	ctx, span := tracing.StartDB(ctx, "DELETE", query)
+	result, err := store.DB.ExecContext(ctx, query, domain, key)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrURLNotFound
+	}
+	return nil
+}
+
+func (store *DatabaseStore) ExportURLs(ctx context.Context, fn func(URLStore) error) (err error) {
+	query := `SELECT ` + urlColumns + ` FROM urls ORDER BY id`
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanURL(rows)
+		if err != nil {
+			return err
 		}
-		return "", err
+		if err = fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (store *DatabaseStore) ImportURLs(ctx context.Context, items []URLStore) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "DatabaseStore.ImportURLs")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
-	return originalURL, nil
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO urls (domain, short_url, original_url, user_id, is_deleted, is_disabled, passthrough, is_prefix,
+			rules, split, password_hash, max_clicks, clicks, title, description, tags, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT DO NOTHING
+	`
+	imported := 0
+	for _, item := range items {
+		if item.CreatedAt.IsZero() {
+			item.CreatedAt = time.Now().UTC()
+		}
+		ruleSet, err := encodeList(item.Rules)
+		if err != nil {
+			return 0, err
+		}
+		split, err := encodeList(item.Split)
+		if err != nil {
+			return 0, err
+		}
+		tags, err := encodeList(item.Tags)
+		if err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, query,
+			item.Domain, item.ShortURL, item.OriginalURL, item.UserID, item.DeletedFlag, item.Disabled, item.Passthrough,
+			item.Prefix, ruleSet, split, item.PasswordHash, item.MaxClicks, item.Clicks, item.Title, item.Description, tags,
+			item.CreatedAt)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			imported += int(n)
+		}
+	}
+	return imported, tx.Commit()
+}
+
+func scanURL(row interface{ Scan(dest ...any) error }) (URLStore, error) {
+	var item URLStore
+	var ruleSet, split, tags []byte
+	err := row.Scan(&item.UUID, &item.Domain, &item.ShortURL, &item.OriginalURL, &item.UserID, &item.DeletedFlag, &item.Disabled,
+		&item.Passthrough, &item.Prefix, &ruleSet, &split, &item.PasswordHash, &item.MaxClicks, &item.Clicks,
+		&item.Title, &item.Description, &tags, &item.CreatedAt)
+	if err == nil && len(ruleSet) > 0 {
+		err = json.Unmarshal(ruleSet, &item.Rules)
+	}
+	if err == nil && len(split) > 0 {
+		err = json.Unmarshal(split, &item.Split)
+	}
+	if err == nil && len(tags) > 0 {
+		err = json.Unmarshal(tags, &item.Tags)
+	}
+	return item, err
+}
+
+func encodeList[T any](list []T) (string, error) {
+	if len(list) == 0 {
+		return "[]", nil
+	}
+	encoded, err := json.Marshal(list)
+	return string(encoded), err
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
 
 func (err ConflictError) Error() string {
-	return fmt.Sprintf("Original URL already exists with short URL: %s", err.ShortURL)
+	return fmt.Sprintf("Original URL already exists with short code: %s", err.URL.ShortURL)
 }
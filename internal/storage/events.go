@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/tracing"
+)
+
+type EventType string
+
+const (
+	EventCreated  EventType = "created"
+	EventUpdated  EventType = "updated"
+	EventDisabled EventType = "disabled"
+	EventEnabled  EventType = "enabled"
+	EventDeleted  EventType = "deleted"
+	EventPurged   EventType = "purged"
+	EventExpired  EventType = "expired"
+)
+
+type (
+	EventStore interface {
+		RecordEvents(ctx context.Context, events []Event) error
+		ListEvents(ctx context.Context, filter EventFilter) (EventPage, error)
+		ExportEvents(ctx context.Context, fn func(Event) error) error
+	}
+	// Event is one entry of the link lifecycle log. Actor is an API key ID,
+	// "admin" or "anonymous".
+	Event struct {
+		ID        int       `json:"id"`
+		Type      EventType `json:"type"`
+		Domain    string    `json:"domain,omitempty"`
+		ShortURL  string    `json:"short_url"`
+		UserID    string    `json:"user_id,omitempty"`
+		Actor     string    `json:"actor"`
+		Field     string    `json:"field,omitempty"`
+		OldValue  string    `json:"old_value,omitempty"`
+		NewValue  string    `json:"new_value,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	EventFilter struct {
+		Domain   string
+		UserID   string
+		ShortURL string
+		From     time.Time
+		To       time.Time
+		Cursor   string
+		Limit    int
+	}
+	EventPage struct {
+		Events     []Event
+		NextCursor string
+	}
+
+	eventCursor struct {
+		CreatedAt time.Time `json:"t"`
+		ID        int       `json:"i"`
+	}
+)
+
+func (filter EventFilter) matches(event Event) bool {
+	return event.Domain == filter.Domain &&
+		(filter.UserID == "" || event.UserID == filter.UserID) &&
+		(filter.ShortURL == "" || event.ShortURL == filter.ShortURL) &&
+		(filter.From.IsZero() || !event.CreatedAt.Before(filter.From)) &&
+		(filter.To.IsZero() || event.CreatedAt.Before(filter.To))
+}
+
+func (filter EventFilter) decodeCursor() (*eventCursor, error) {
+	if filter.Cursor == "" {
+		return nil, nil
+	}
+	var c eventCursor
+	if err := decodeCursor(filter.Cursor, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (filter EventFilter) page(events []Event) EventPage {
+	if filter.Limit <= 0 || len(events) <= filter.Limit {
+		return EventPage{Events: events}
+	}
+	events = events[:filter.Limit]
+	last := events[len(events)-1]
+	return EventPage{Events: events, NextCursor: encodeCursor(eventCursor{CreatedAt: last.CreatedAt, ID: last.ID})}
+}
+
+func compareEvents(a, b Event) int {
+	return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+}
+
+func (store *MemoryStore) RecordEvents(ctx context.Context, events []Event) (err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.RecordEvents")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.OpenFile(store.Config.Get().EventLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, event := range events {
+		event.ID = len(store.events) + 1
+		if err = encoder.Encode(event); err != nil {
+			return err
+		}
+		store.events = append(store.events, event)
+	}
+	return nil
+}
+
+func (store *MemoryStore) ListEvents(ctx context.Context, filter EventFilter) (EventPage, error) {
+	_, span := tracing.Start(ctx, "MemoryStore.ListEvents")
+	defer span.End()
+
+	c, err := filter.decodeCursor()
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var result []Event
+	for _, event := range store.events {
+		if !filter.matches(event) {
+			continue
+		}
+		if c != nil && compareEvents(event, Event{CreatedAt: c.CreatedAt, ID: c.ID}) >= 0 {
+			continue
+		}
+		result = append(result, event)
+	}
+	slices.SortFunc(result, func(a, b Event) int { return compareEvents(b, a) })
+	return filter.page(result), nil
+}
+
+func (store *MemoryStore) ExportEvents(ctx context.Context, fn func(Event) error) (err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.ExportEvents")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.RLock()
+	events := slices.Clone(store.events)
+	store.mu.RUnlock()
+
+	slices.SortStableFunc(events, compareEvents)
+	for _, event := range events {
+		if err = fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) loadEvents() error {
+	file, err := os.Open(store.Config.Get().EventLogPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		store.events = append(store.events, event)
+	}
+	return nil
+}
+
+func (store *DatabaseStore) RecordEvents(ctx context.Context, events []Event) (err error) {
+	ctx, span := tracing.Start(ctx, "DatabaseStore.RecordEvents")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO url_events (type, domain, short_url, user_id, actor, field, old_value, new_value, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9)
+	`
+	for _, event := range events {
+		_, err = tx.ExecContext(ctx, query, event.Type, event.Domain, event.ShortURL, event.UserID, event.Actor,
+			event.Field, event.OldValue, event.NewValue, event.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *DatabaseStore) ListEvents(ctx context.Context, filter EventFilter) (_ EventPage, err error) {
+	c, err := filter.decodeCursor()
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	where("domain = $%d", filter.Domain)
+	if filter.UserID != "" {
+		where("user_id = $%d", filter.UserID)
+	}
+	if filter.ShortURL != "" {
+		where("short_url = $%d", filter.ShortURL)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+	if c != nil {
+		args = append(args, c.CreatedAt, c.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `
+		SELECT id, type, domain, short_url, COALESCE(user_id, ''), actor,
+			COALESCE(field, ''), COALESCE(old_value, ''), COALESCE(new_value, ''), created_at
+		FROM url_events`
+	query += " WHERE " + strings.Join(conditions, " AND ")
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return EventPage{}, err
+	}
+	defer rows.Close()
+
+	var result []Event
+	for rows.Next() {
+		var event Event
+		err = rows.Scan(&event.ID, &event.Type, &event.Domain, &event.ShortURL, &event.UserID, &event.Actor,
+			&event.Field, &event.OldValue, &event.NewValue, &event.CreatedAt)
+		if err != nil {
+			return EventPage{}, err
+		}
+		result = append(result, event)
+	}
+	if err = rows.Err(); err != nil {
+		return EventPage{}, err
+	}
+	return filter.page(result), nil
+}
+
+func (store *DatabaseStore) ExportEvents(ctx context.Context, fn func(Event) error) (err error) {
+	query := `
+		SELECT id, type, domain, short_url, COALESCE(user_id, ''), actor,
+			COALESCE(field, ''), COALESCE(old_value, ''), COALESCE(new_value, ''), created_at
+		FROM url_events ORDER BY created_at, id`
+	ctx, span := tracing.StartDB(ctx, "SELECT", query)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := store.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event Event
+		err = rows.Scan(&event.ID, &event.Type, &event.Domain, &event.ShortURL, &event.UserID, &event.Actor,
+			&event.Field, &event.OldValue, &event.NewValue, &event.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if err = fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
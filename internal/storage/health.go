@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+type (
+	HealthChecker interface {
+		CheckHealth(ctx context.Context) []ComponentHealth
+	}
+	// ComponentHealth.Error is only logged, the probe is public.
+	ComponentHealth struct {
+		Name       string  `json:"name"`
+		Status     string  `json:"status"`
+		DurationMS float64 `json:"duration_ms"`
+		Error      string  `json:"-"`
+	}
+)
+
+func checkComponent(ctx context.Context, name string, check func(ctx context.Context) error) ComponentHealth {
+	start := time.Now()
+	err := check(ctx)
+	health := ComponentHealth{
+		Name:       name,
+		Status:     HealthUp,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+	return health
+}
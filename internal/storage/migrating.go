@@ -0,0 +1,682 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+)
+
+const (
+	ReadFromOld = "old"
+	ReadFromNew = "new"
+
+	MigrationPending    = "pending"
+	MigrationBackfill   = "backfill"
+	MigrationVerify     = "verify"
+	MigrationCompleted  = "completed"
+	MigrationIncomplete = "incomplete"
+	MigrationFailed     = "failed"
+
+	migrationBatchSize    = 500
+	maxShadowReads        = 16
+	maxReportedMismatches = 20
+)
+
+type (
+	// MigratingStore moves a running instance from Old to New. Writes go to Old
+	// first and are replayed on New, reads are shadowed on the other backend.
+	MigratingStore struct {
+		Config *config.Source
+		Old    StoreHandler
+		New    StoreHandler
+
+		shadow chan struct{}
+
+		mu     sync.RWMutex
+		status MigrationStatus
+
+		shadowReads      atomic.Int64
+		shadowMismatches atomic.Int64
+		writeFailures    atomic.Int64
+	}
+	// MigrationStatus describes the last run. Conflicts are the codes New
+	// refused because it stores their original URL under another code.
+	MigrationStatus struct {
+		State            string     `json:"state"`
+		ReadFrom         string     `json:"read_from"`
+		StartedAt        *time.Time `json:"started_at,omitempty"`
+		FinishedAt       *time.Time `json:"finished_at,omitempty"`
+		CompletedAt      *time.Time `json:"completed_at,omitempty"`
+		Processed        int        `json:"processed"`
+		Copied           int        `json:"copied"`
+		Updated          int        `json:"updated"`
+		Skipped          int        `json:"skipped"`
+		Conflicted       int        `json:"conflicted"`
+		Conflicts        []string   `json:"conflicts,omitempty"`
+		APIKeysCopied    int        `json:"api_keys_copied"`
+		EventsCopied     int        `json:"events_copied"`
+		ClicksCopied     int64      `json:"clicks_copied"`
+		Verified         int        `json:"verified"`
+		Missing          int        `json:"missing"`
+		Mismatched       int        `json:"mismatched"`
+		Mismatches       []string   `json:"mismatches,omitempty"`
+		ShadowReads      int64      `json:"shadow_reads"`
+		ShadowMismatches int64      `json:"shadow_mismatches"`
+		WriteFailures    int64      `json:"write_failures"`
+		Error            string     `json:"error,omitempty"`
+	}
+)
+
+func Database(store StoreHandler) (*DatabaseStore, bool) {
+	if migrating, ok := store.(*MigratingStore); ok {
+		if db, ok := migrating.New.(*DatabaseStore); ok {
+			return db, true
+		}
+		store = migrating.Old
+	}
+	db, ok := store.(*DatabaseStore)
+	return db, ok
+}
+
+func NewMigratingStore(cfg *config.Source, old, new StoreHandler) *MigratingStore {
+	return &MigratingStore{Config: cfg, Old: old, New: new, shadow: make(chan struct{}, maxShadowReads)}
+}
+
+func (store *MigratingStore) Initialize(ctx context.Context) error {
+	if err := store.Old.Initialize(ctx); err != nil {
+		return err
+	}
+	if err := store.New.Initialize(ctx); err != nil {
+		return err
+	}
+	return store.loadStatus()
+}
+
+func (store *MigratingStore) loadStatus() error {
+	data, err := os.ReadFile(store.Config.Get().MigrationStatusPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return json.Unmarshal(data, &store.status)
+}
+
+func (store *MigratingStore) saveStatus() error {
+	store.mu.RLock()
+	status := store.status
+	store.mu.RUnlock()
+	return writeJSONLines(store.Config.Get().MigrationStatusPath, []MigrationStatus{status}, 0600)
+}
+
+func (store *MigratingStore) Status() MigrationStatus {
+	store.mu.RLock()
+	status := store.status
+	store.mu.RUnlock()
+
+	if status.State == "" {
+		status.State = MigrationPending
+	}
+	status.ReadFrom = store.readFrom()
+	status.Conflicts = append([]string(nil), status.Conflicts...)
+	status.Mismatches = append([]string(nil), status.Mismatches...)
+	status.ShadowReads = store.shadowReads.Load()
+	status.ShadowMismatches = store.shadowMismatches.Load()
+	status.WriteFailures = store.writeFailures.Load()
+	return status
+}
+
+func (store *MigratingStore) update(fn func(status *MigrationStatus)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	fn(&store.status)
+}
+
+// Run resumes an interrupted run after the links it had processed.
+func (store *MigratingStore) Run(ctx context.Context) error {
+	now := time.Now().UTC()
+	store.update(func(status *MigrationStatus) {
+		if status.State == MigrationBackfill || status.State == MigrationVerify {
+			status.State = MigrationBackfill
+			status.Verified, status.Missing, status.Mismatched, status.Mismatches = 0, 0, 0, nil
+			return
+		}
+		*status = MigrationStatus{State: MigrationBackfill, StartedAt: &now, CompletedAt: status.CompletedAt}
+	})
+
+	err := store.saveStatus()
+	if err == nil {
+		err = store.backfill(ctx)
+	}
+	if err == nil {
+		store.update(func(status *MigrationStatus) { status.State = MigrationVerify })
+		err = store.verify(ctx)
+	}
+
+	finished := time.Now().UTC()
+	store.update(func(status *MigrationStatus) {
+		status.FinishedAt = &finished
+		status.CompletedAt = nil
+		switch {
+		case err != nil:
+			status.State = MigrationFailed
+			status.Error = err.Error()
+		case status.Conflicted > 0 || status.Missing > 0 || status.Mismatched > 0:
+			status.State = MigrationIncomplete
+		default:
+			status.State = MigrationCompleted
+			status.CompletedAt = &finished
+		}
+	})
+	if saveErr := store.saveStatus(); saveErr != nil {
+		logger.FromContext(ctx).Errorw("save storage migration status failed", "error", saveErr)
+	}
+
+	status := store.Status()
+	logger.FromContext(ctx).Infow("storage migration finished", "state", status.State, "copied", status.Copied,
+		"updated", status.Updated, "skipped", status.Skipped, "conflicted", status.Conflicted,
+		"events_copied", status.EventsCopied, "clicks_copied", status.ClicksCopied, "missing", status.Missing,
+		"mismatched", status.Mismatched)
+	if status.State == MigrationIncomplete {
+		logger.FromContext(ctx).Warnw("storage migration incomplete, reads stay on the old backend",
+			"conflicts", status.Conflicts, "mismatches", status.Mismatches)
+	}
+	return err
+}
+
+func (store *MigratingStore) backfill(ctx context.Context) error {
+	store.mu.RLock()
+	skip := store.status.Processed
+	store.mu.RUnlock()
+
+	batch := make([]URLStore, 0, migrationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		copied, updated, conflicts, err := store.importNew(ctx, batch)
+		if err != nil {
+			return err
+		}
+		clicks, err := store.copyClicks(ctx, batch, conflicts)
+		if err != nil {
+			return err
+		}
+		store.update(func(status *MigrationStatus) {
+			status.Processed += len(batch)
+			status.Copied += copied
+			status.Updated += updated
+			status.Skipped += len(batch) - copied - updated - len(conflicts)
+			status.Conflicted += len(conflicts)
+			status.ClicksCopied += clicks
+			for _, code := range conflicts {
+				if len(status.Conflicts) < maxReportedMismatches {
+					status.Conflicts = append(status.Conflicts, code)
+				}
+			}
+		})
+		status := store.Status()
+		logger.FromContext(ctx).Infow("storage migration progress", "processed", status.Processed,
+			"copied", status.Copied, "updated", status.Updated)
+		batch = batch[:0]
+		return store.saveStatus()
+	}
+
+	err := store.Old.ExportURLs(ctx, func(item URLStore) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		batch = append(batch, item)
+		if len(batch) == migrationBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.Old.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		_, err := store.New.FindAPIKey(ctx, key.Hash)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrAPIKeyNotFound) {
+			return err
+		}
+		if err = store.New.CreateAPIKey(ctx, key); err != nil {
+			return err
+		}
+		store.update(func(status *MigrationStatus) { status.APIKeysCopied++ })
+	}
+	return store.copyEvents(ctx)
+}
+
+// copyEvents matches events by content, those written since the migration
+// started are on both backends.
+func (store *MigratingStore) copyEvents(ctx context.Context) error {
+	have := make(map[Event]int)
+	err := store.New.ExportEvents(ctx, func(event Event) error {
+		have[eventKey(event)]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := make([]Event, 0, migrationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := store.New.RecordEvents(ctx, batch); err != nil {
+			return err
+		}
+		store.update(func(status *MigrationStatus) { status.EventsCopied += len(batch) })
+		batch = batch[:0]
+		return nil
+	}
+	err = store.Old.ExportEvents(ctx, func(event Event) error {
+		if key := eventKey(event); have[key] > 0 {
+			have[key]--
+			return nil
+		}
+		batch = append(batch, event)
+		if len(batch) == migrationBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+func eventKey(event Event) Event {
+	event.ID = 0
+	event.CreatedAt = event.CreatedAt.UTC().Truncate(time.Microsecond)
+	return event
+}
+
+func (store *MigratingStore) copyClicks(ctx context.Context, items []URLStore, conflicts []string) (int64, error) {
+	var missing []VariantClicks
+	var total int64
+	for _, item := range items {
+		if slices.Contains(conflicts, item.ShortURL) {
+			continue
+		}
+		want, err := store.Old.ListClicks(ctx, item.Domain, item.ShortURL)
+		if err != nil || len(want) == 0 {
+			return total, err
+		}
+		got, err := store.New.ListClicks(ctx, item.Domain, item.ShortURL)
+		if err != nil {
+			return total, err
+		}
+		for variant, clicks := range want {
+			if clicks > got[variant] {
+				missing = append(missing, VariantClicks{Domain: item.Domain, ShortURL: item.ShortURL,
+					Variant: variant, Clicks: clicks - got[variant]})
+				total += clicks - got[variant]
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	return total, store.New.ImportClicks(ctx, missing)
+}
+
+func (store *MigratingStore) verify(ctx context.Context) error {
+	return store.Old.ExportURLs(ctx, func(want URLStore) error {
+		got, err := store.New.GetURL(ctx, want.Domain, want.ShortURL)
+		if err != nil && !errors.Is(err, ErrURLNotFound) {
+			return err
+		}
+
+		store.update(func(status *MigrationStatus) {
+			status.Verified++
+			switch {
+			case err != nil:
+				status.Missing++
+			case !sameURL(want, got):
+				status.Mismatched++
+				if len(status.Mismatches) < maxReportedMismatches {
+					status.Mismatches = append(status.Mismatches, want.ShortURL)
+				}
+			}
+		})
+		return nil
+	})
+}
+
+func (store *MigratingStore) importNew(ctx context.Context, items []URLStore) (int, int, []string, error) {
+	imported, err := store.New.ImportURLs(ctx, items)
+	if err != nil || imported == len(items) {
+		return imported, 0, nil, err
+	}
+	updated := 0
+	var conflicts []string
+	for _, item := range items {
+		got, err := store.New.GetURL(ctx, item.Domain, item.ShortURL)
+		if errors.Is(err, ErrURLNotFound) {
+			conflicts = append(conflicts, item.ShortURL)
+			continue
+		}
+		if err != nil {
+			return imported, updated, conflicts, err
+		}
+		if sameURL(item, got) {
+			continue
+		}
+		_, err = store.New.UpdateURL(ctx, item.Domain, item.ShortURL, replacement(item))
+		if errors.As(err, &ConflictError{}) {
+			conflicts = append(conflicts, item.ShortURL)
+			continue
+		}
+		if err != nil {
+			return imported, updated, conflicts, err
+		}
+		updated++
+	}
+	return imported, updated, conflicts, nil
+}
+
+func replacement(item URLStore) URLUpdate {
+	return URLUpdate{
+		OriginalURL:  &item.OriginalURL,
+		UserID:       &item.UserID,
+		Disabled:     &item.Disabled,
+		Passthrough:  &item.Passthrough,
+		Prefix:       &item.Prefix,
+		Rules:        &item.Rules,
+		Split:        &item.Split,
+		PasswordHash: &item.PasswordHash,
+		MaxClicks:    &item.MaxClicks,
+		Clicks:       &item.Clicks,
+		Title:        &item.Title,
+		Description:  &item.Description,
+		Tags:         &item.Tags,
+		Deleted:      &item.DeletedFlag,
+	}
+}
+
+func (store *MigratingStore) replayImport(ctx context.Context, operation string, items []URLStore) error {
+	_, _, conflicts, err := store.importNew(ctx, items)
+	if err == nil && len(conflicts) > 0 {
+		err = fmt.Errorf("original url already stored on the new backend: %s", strings.Join(conflicts, ", "))
+	}
+	return store.replay(ctx, operation, err)
+}
+
+func sameURL(a, b URLStore) bool {
+	return a.Domain == b.Domain && a.ShortURL == b.ShortURL && a.OriginalURL == b.OriginalURL && a.UserID == b.UserID &&
+		a.DeletedFlag == b.DeletedFlag && a.Disabled == b.Disabled && a.Passthrough == b.Passthrough &&
+		a.Prefix == b.Prefix && sameList(a.Rules, b.Rules) && sameList(a.Split, b.Split) &&
+		a.PasswordHash == b.PasswordHash && a.MaxClicks == b.MaxClicks && a.Clicks == b.Clicks &&
+		a.Title == b.Title && a.Description == b.Description && sameList(a.Tags, b.Tags)
+}
+
+// sameList compares the stored form, rule times may come back in another
+// location.
+func sameList[T any](a, b []T) bool {
+	encodedA, errA := encodeList(a)
+	encodedB, errB := encodeList(b)
+	return errA == nil && errB == nil && encodedA == encodedB
+}
+
+// readFrom stays on Old until a run has completed.
+func (store *MigratingStore) readFrom() string {
+	store.mu.RLock()
+	completed := store.status.CompletedAt != nil
+	store.mu.RUnlock()
+	if completed && store.Config.Get().MigrationReadFrom == ReadFromNew {
+		return ReadFromNew
+	}
+	return ReadFromOld
+}
+
+func (store *MigratingStore) reader() (primary, shadow StoreHandler) {
+	if store.readFrom() == ReadFromNew {
+		return store.New, store.Old
+	}
+	return store.Old, store.New
+}
+
+// replay only fails the request once New serves reads.
+func (store *MigratingStore) replay(ctx context.Context, operation string, err error) error {
+	if err == nil || errors.Is(err, ErrURLNotFound) || errors.Is(err, ErrAPIKeyNotFound) {
+		return nil
+	}
+	store.writeFailures.Add(1)
+	logger.FromContext(ctx).Errorw("storage migration write failed", "operation", operation, "error", err)
+	if store.readFrom() == ReadFromNew {
+		return err
+	}
+	return nil
+}
+
+func (store *MigratingStore) CheckHealth(ctx context.Context) []ComponentHealth {
+	return append(store.Old.CheckHealth(ctx), store.New.CheckHealth(ctx)...)
+}
+
+func (store *MigratingStore) Get(ctx context.Context, domain, key string) (URLStore, error) {
+	primary, shadow := store.reader()
+	item, err := primary.Get(ctx, domain, key)
+	store.shadowGet(ctx, shadow, domain, key, item, err)
+	return item, err
+}
+
+// shadowGet drops lookups rather than queue them when too many are in flight.
+func (store *MigratingStore) shadowGet(ctx context.Context, shadow StoreHandler, domain, key string, want URLStore,
+	wantErr error) {
+	select {
+	case store.shadow <- struct{}{}:
+	default:
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-store.shadow }()
+		got, err := shadow.Get(ctx, domain, key)
+		store.shadowReads.Add(1)
+		if lookupOutcome(got, err) != lookupOutcome(want, wantErr) {
+			store.shadowMismatches.Add(1)
+			logger.FromContext(ctx).Warnw("storage migration shadow read mismatch", "id", key, "error", err)
+		}
+	}()
+}
+
+func lookupOutcome(item URLStore, err error) string {
+	switch {
+	case err == nil:
+		return fmt.Sprintf("%t %s %d %d %t %d %s", item.Prefix, item.Passthrough, len(item.Rules), len(item.Split),
+			item.PasswordHash != "", item.MaxClicks, item.OriginalURL)
+	case errors.Is(err, ErrURLNotFound):
+		return "not found"
+	case errors.Is(err, ErrURLDeleted):
+		return "deleted"
+	case errors.Is(err, ErrURLDisabled):
+		return "disabled"
+	default:
+		return "error"
+	}
+}
+
+func (store *MigratingStore) Save(ctx context.Context, item URLStore) (URLStore, error) {
+	item, err := store.Old.Save(ctx, item)
+	if err != nil {
+		return item, err
+	}
+	return item, store.replayImport(ctx, "save", []URLStore{item})
+}
+
+func (store *MigratingStore) UseClick(ctx context.Context, domain, key string) (URLStore, error) {
+	item, err := store.Old.UseClick(ctx, domain, key)
+	if err != nil {
+		return item, err
+	}
+	_, err = store.New.UseClick(ctx, domain, key)
+	return item, store.replay(ctx, "use click", err)
+}
+
+func (store *MigratingStore) SaveBatch(ctx context.Context, urlStore *[]URLStore) ([]URLStore, error) {
+	saved, err := store.Old.SaveBatch(ctx, urlStore)
+	if err != nil {
+		return saved, err
+	}
+
+	items := make([]URLStore, 0, len(saved))
+	for _, item := range saved {
+		item.CorrelationID = ""
+		items = append(items, item)
+	}
+	return saved, store.replayImport(ctx, "save batch", items)
+}
+
+func (store *MigratingStore) GetUserURLs(ctx context.Context, userID string, filter URLFilter) (URLPage, error) {
+	primary, _ := store.reader()
+	return primary.GetUserURLs(ctx, userID, filter)
+}
+
+func (store *MigratingStore) DeleteURLs(ctx context.Context, userID string, keys []string) ([]URLStore, error) {
+	deleted, err := store.Old.DeleteURLs(ctx, userID, keys)
+	if err != nil || len(deleted) == 0 {
+		return deleted, err
+	}
+	keys = make([]string, 0, len(deleted))
+	for _, item := range deleted {
+		keys = append(keys, item.ShortURL)
+	}
+	_, err = store.New.DeleteURLs(ctx, userID, keys)
+	return deleted, store.replay(ctx, "delete", err)
+}
+
+func (store *MigratingStore) GetURL(ctx context.Context, domain, key string) (URLStore, error) {
+	primary, _ := store.reader()
+	return primary.GetURL(ctx, domain, key)
+}
+
+func (store *MigratingStore) FindURLs(ctx context.Context, originalURL string) ([]URLStore, error) {
+	primary, _ := store.reader()
+	return primary.FindURLs(ctx, originalURL)
+}
+
+func (store *MigratingStore) UpdateURL(ctx context.Context, domain, key string, update URLUpdate) (URLStore, error) {
+	item, err := store.Old.UpdateURL(ctx, domain, key, update)
+	if err != nil {
+		return item, err
+	}
+	_, err = store.New.UpdateURL(ctx, domain, key, update)
+	return item, store.replay(ctx, "update", err)
+}
+
+func (store *MigratingStore) DeleteURL(ctx context.Context, domain, key string) error {
+	if err := store.Old.DeleteURL(ctx, domain, key); err != nil {
+		return err
+	}
+	return store.replay(ctx, "purge", store.New.DeleteURL(ctx, domain, key))
+}
+
+func (store *MigratingStore) ExportURLs(ctx context.Context, fn func(URLStore) error) error {
+	primary, _ := store.reader()
+	return primary.ExportURLs(ctx, fn)
+}
+
+func (store *MigratingStore) ImportURLs(ctx context.Context, items []URLStore) (int, error) {
+	imported, err := store.Old.ImportURLs(ctx, items)
+	if err != nil {
+		return imported, err
+	}
+	return imported, store.replayImport(ctx, "import", items)
+}
+
+func (store *MigratingStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	if err := store.Old.CreateAPIKey(ctx, key); err != nil {
+		return err
+	}
+	return store.replay(ctx, "create api key", store.New.CreateAPIKey(ctx, key))
+}
+
+func (store *MigratingStore) FindAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	primary, _ := store.reader()
+	return primary.FindAPIKey(ctx, hash)
+}
+
+func (store *MigratingStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	primary, _ := store.reader()
+	return primary.ListAPIKeys(ctx)
+}
+
+func (store *MigratingStore) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := store.Old.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	return store.replay(ctx, "revoke api key", store.New.RevokeAPIKey(ctx, id))
+}
+
+func (store *MigratingStore) UseAPIKeyQuota(ctx context.Context, id string, n int) error {
+	if err := store.Old.UseAPIKeyQuota(ctx, id, n); err != nil {
+		return err
+	}
+	return store.replay(ctx, "use api key quota", store.New.UseAPIKeyQuota(ctx, id, n))
+}
+
+func (store *MigratingStore) RecordEvents(ctx context.Context, events []Event) error {
+	if err := store.Old.RecordEvents(ctx, events); err != nil {
+		return err
+	}
+	return store.replay(ctx, "record events", store.New.RecordEvents(ctx, events))
+}
+
+func (store *MigratingStore) ListEvents(ctx context.Context, filter EventFilter) (EventPage, error) {
+	primary, _ := store.reader()
+	return primary.ListEvents(ctx, filter)
+}
+
+func (store *MigratingStore) ExportEvents(ctx context.Context, fn func(Event) error) error {
+	primary, _ := store.reader()
+	return primary.ExportEvents(ctx, fn)
+}
+
+func (store *MigratingStore) RecordClick(ctx context.Context, domain, key, variant string) error {
+	if err := store.Old.RecordClick(ctx, domain, key, variant); err != nil {
+		return err
+	}
+	return store.replay(ctx, "record click", store.New.RecordClick(ctx, domain, key, variant))
+}
+
+func (store *MigratingStore) ImportClicks(ctx context.Context, clicks []VariantClicks) error {
+	if err := store.Old.ImportClicks(ctx, clicks); err != nil {
+		return err
+	}
+	return store.replay(ctx, "import clicks", store.New.ImportClicks(ctx, clicks))
+}
+
+func (store *MigratingStore) ListClicks(ctx context.Context, domain, key string) (map[string]int64, error) {
+	primary, _ := store.reader()
+	return primary.ListClicks(ctx, domain, key)
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	cfg := config.Default()
+	dir := t.TempDir()
+	cfg.FileStoragePath = dir + "/urls.json"
+	cfg.APIKeysPath = dir + "/api_keys.json"
+	cfg.EventLogPath = dir + "/events.json"
+	cfg.ClickLogPath = dir + "/clicks.json"
+	cfg.MigrationStatusPath = dir + "/migration.json"
+	return &cfg
+}
+
+func TestMigratingStore(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	old := &MemoryStore{Config: config.New(*cfg)}
+	target := &MemoryStore{Config: config.New(*newTestConfig(t))}
+	historical, err := old.Save(context.TODO(), URLStore{OriginalURL: "https://practicum.yandex.ru", UserID: "owner"})
+	require.NoError(t, err)
+	require.NoError(t, old.CreateAPIKey(context.TODO(), APIKey{ID: "key", Hash: "hash"}))
+
+	store := NewMigratingStore(config.New(*cfg), old, target)
+	require.NoError(t, store.Run(context.TODO()))
+
+	status := store.Status()
+	assert.Equal(t, MigrationCompleted, status.State)
+	assert.Equal(t, 1, status.Copied)
+	assert.Equal(t, 1, status.APIKeysCopied)
+	assert.Equal(t, 1, status.Verified)
+	assert.Zero(t, status.Missing+status.Mismatched)
+
+	written, err := store.Save(context.TODO(), URLStore{OriginalURL: "https://ya.ru", UserID: "owner"})
+	require.NoError(t, err)
+	for _, id := range []string{historical.ShortURL, written.ShortURL} {
+		want, err := old.GetURL(context.TODO(), "", id)
+		require.NoError(t, err)
+		got, err := target.GetURL(context.TODO(), "", id)
+		require.NoError(t, err)
+		assert.True(t, sameURL(want, got))
+	}
+
+	id := written.ShortURL
+	deleted, err := store.DeleteURLs(context.TODO(), "owner", []string{id})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, id, deleted[0].ShortURL)
+	_, err = target.Get(context.TODO(), "", id)
+	assert.ErrorIs(t, err, ErrURLDeleted)
+
+	cfg.MigrationReadFrom = ReadFromNew
+	store.Config = config.New(*cfg)
+	_, err = store.FindAPIKey(context.TODO(), "hash")
+	assert.NoError(t, err)
+	assert.Equal(t, ReadFromNew, store.Status().ReadFrom)
+}
+
+func TestMigratingStoreConflicts(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	cfg.MigrationReadFrom = ReadFromNew
+	lines := `{"short_url":"first","original_url":"https://practicum.yandex.ru","user_id":"owner"}
+{"short_url":"second","original_url":"https://practicum.yandex.ru","user_id":"owner"}
+`
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, []byte(lines), 0666))
+	old := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, old.Initialize(context.TODO()))
+	target := &MemoryStore{Config: config.New(*newTestConfig(t))}
+
+	store := NewMigratingStore(config.New(*cfg), old, target)
+	require.NoError(t, store.Run(context.TODO()))
+
+	status := store.Status()
+	assert.Equal(t, MigrationIncomplete, status.State)
+	assert.Equal(t, 1, status.Copied)
+	assert.Equal(t, 1, status.Conflicted)
+	assert.Equal(t, []string{"second"}, status.Conflicts)
+	assert.Equal(t, ReadFromOld, status.ReadFrom)
+
+	_, err := store.GetURL(context.TODO(), "", "second")
+	assert.NoError(t, err)
+}
+
+func TestMigratingStoreUpsertAndResume(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	cfg.MigrationReadFrom = ReadFromNew
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath,
+		[]byte(`{"short_url":"code","original_url":"https://practicum.yandex.ru","user_id":"owner"}`+"\n"), 0666))
+	targetCfg := newTestConfig(t)
+	require.NoError(t, os.WriteFile(targetCfg.FileStoragePath,
+		[]byte(`{"short_url":"code","original_url":"https://ya.ru","user_id":"owner"}`+"\n"), 0666))
+	old := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, old.Initialize(context.TODO()))
+	target := &MemoryStore{Config: config.New(*targetCfg)}
+	require.NoError(t, target.Initialize(context.TODO()))
+
+	event := Event{Type: EventCreated, ShortURL: "code", Actor: "owner", CreatedAt: time.Now()}
+	require.NoError(t, old.RecordEvents(context.TODO(), []Event{event}))
+	require.NoError(t, old.RecordClick(context.TODO(), "", "code", ""))
+	require.NoError(t, old.RecordClick(context.TODO(), "", "code", ""))
+
+	store := NewMigratingStore(config.New(*cfg), old, target)
+	require.NoError(t, store.Run(context.TODO()))
+	status := store.Status()
+	assert.Equal(t, MigrationCompleted, status.State)
+	assert.Equal(t, 1, status.Processed)
+	assert.Equal(t, 1, status.Updated)
+	assert.Equal(t, 1, status.EventsCopied)
+	assert.Equal(t, int64(2), status.ClicksCopied)
+
+	got, err := target.GetURL(context.TODO(), "", "code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru", got.OriginalURL)
+	clicks, err := target.ListClicks(context.TODO(), "", "code")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), clicks[""])
+
+	require.NoError(t, store.Run(context.TODO()))
+	status = store.Status()
+	assert.Equal(t, MigrationCompleted, status.State)
+	assert.Zero(t, status.Updated+status.EventsCopied)
+	assert.Zero(t, status.ClicksCopied)
+
+	restarted := NewMigratingStore(config.New(*cfg), &MemoryStore{Config: config.New(*cfg)},
+		&MemoryStore{Config: config.New(*targetCfg)})
+	require.NoError(t, restarted.Initialize(context.TODO()))
+	status = restarted.Status()
+	assert.Equal(t, MigrationCompleted, status.State)
+	assert.NotNil(t, status.CompletedAt)
+	assert.Equal(t, ReadFromNew, status.ReadFrom)
+}
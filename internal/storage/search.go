@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	SortCreatedAt = "created_at"
+	SortClicks    = "clicks"
+
+	DefaultURLLimit = 100
+	MaxURLLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type (
+	// URLPage is one page of a link listing, NextCursor is empty on the last.
+	URLPage struct {
+		URLs       []URLStore
+		NextCursor string
+	}
+
+	// cursor is the sort key of the last link on a page.
+	cursor struct {
+		Sort      string    `json:"s"`
+		Desc      bool      `json:"r,omitempty"`
+		CreatedAt time.Time `json:"t,omitempty"`
+		Clicks    int64     `json:"c,omitempty"`
+		Domain    string    `json:"d,omitempty"`
+		ShortURL  string    `json:"k"`
+	}
+
+	linkKey struct {
+		domain, shortURL string
+	}
+
+	// searchIndex only narrows down the candidates, like a trigram index in
+	// Postgres.
+	searchIndex struct {
+		words    map[string]map[linkKey]struct{}
+		trigrams map[string]map[linkKey]struct{}
+	}
+)
+
+func (filter URLFilter) sortField() string {
+	if filter.Sort == "" {
+		return SortCreatedAt
+	}
+	return filter.Sort
+}
+
+func (filter URLFilter) decodeCursor() (*cursor, error) {
+	if filter.Cursor == "" {
+		return nil, nil
+	}
+	var c cursor
+	if err := decodeCursor(filter.Cursor, &c); err != nil || c.Sort != filter.sortField() || c.Desc != filter.Desc {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (filter URLFilter) encodeCursor(item URLStore) string {
+	c := cursor{Sort: filter.sortField(), Desc: filter.Desc, Domain: item.Domain, ShortURL: item.ShortURL}
+	if c.Sort == SortClicks {
+		c.Clicks = item.TotalClicks
+	} else {
+		c.CreatedAt = item.CreatedAt
+	}
+	return encodeCursor(c)
+}
+
+func encodeCursor(c any) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string, c any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(raw, c) != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+func (filter URLFilter) compare(a, b URLStore) int {
+	var byField int
+	if filter.sortField() == SortClicks {
+		byField = cmp.Compare(a.TotalClicks, b.TotalClicks)
+	} else {
+		byField = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	return cmp.Or(byField, cmp.Compare(a.Domain, b.Domain), cmp.Compare(a.ShortURL, b.ShortURL))
+}
+
+func (filter URLFilter) after(item URLStore, c *cursor) bool {
+	last := URLStore{Domain: c.Domain, ShortURL: c.ShortURL, CreatedAt: c.CreatedAt, TotalClicks: c.Clicks}
+	order := filter.compare(item, last)
+	if filter.Desc {
+		return order < 0
+	}
+	return order > 0
+}
+
+func (filter URLFilter) page(items []URLStore, c *cursor) URLPage {
+	slices.SortFunc(items, func(a, b URLStore) int {
+		if filter.Desc {
+			return filter.compare(b, a)
+		}
+		return filter.compare(a, b)
+	})
+	if c != nil {
+		start := slices.IndexFunc(items, func(item URLStore) bool { return filter.after(item, c) })
+		if start < 0 {
+			start = len(items)
+		}
+		items = items[start:]
+	}
+	if filter.Limit <= 0 || len(items) <= filter.Limit {
+		return URLPage{URLs: items}
+	}
+	items = items[:filter.Limit]
+	return URLPage{URLs: items, NextCursor: filter.encodeCursor(items[len(items)-1])}
+}
+
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func trigrams(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	var result []string
+	for i := 0; i+3 <= len(runes); i++ {
+		if trigram := string(runes[i : i+3]); !slices.Contains(result, trigram) {
+			result = append(result, trigram)
+		}
+	}
+	return result
+}
+
+// searchMatches wants every word of query in the title or tags, or all of
+// query in the original URL.
+func searchMatches(item URLStore, query string) bool {
+	if strings.Contains(strings.ToLower(item.OriginalURL), strings.ToLower(query)) {
+		return true
+	}
+	words := searchWords(query)
+	if len(words) == 0 {
+		return false
+	}
+	var known []string
+	known = append(known, searchWords(item.Title)...)
+	for _, tag := range item.Tags {
+		known = append(known, searchWords(tag)...)
+	}
+	for _, word := range words {
+		if !slices.Contains(known, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *searchIndex) add(item URLStore) {
+	if idx.words == nil {
+		idx.words = make(map[string]map[linkKey]struct{})
+		idx.trigrams = make(map[string]map[linkKey]struct{})
+	}
+	key := linkKey{item.Domain, item.ShortURL}
+	for _, word := range indexWords(item) {
+		addPosting(idx.words, word, key)
+	}
+	for _, trigram := range trigrams(item.OriginalURL) {
+		addPosting(idx.trigrams, trigram, key)
+	}
+}
+
+func (idx *searchIndex) remove(item URLStore) {
+	key := linkKey{item.Domain, item.ShortURL}
+	for _, word := range indexWords(item) {
+		removePosting(idx.words, word, key)
+	}
+	for _, trigram := range trigrams(item.OriginalURL) {
+		removePosting(idx.trigrams, trigram, key)
+	}
+}
+
+// candidates returns false when query is too short for the index.
+func (idx *searchIndex) candidates(query string) (map[linkKey]struct{}, bool) {
+	words, grams := searchWords(query), trigrams(query)
+	if len(grams) == 0 {
+		return nil, false
+	}
+	result := make(map[linkKey]struct{})
+	for key := range intersect(idx.words, words) {
+		result[key] = struct{}{}
+	}
+	for key := range intersect(idx.trigrams, grams) {
+		result[key] = struct{}{}
+	}
+	return result, true
+}
+
+func indexWords(item URLStore) []string {
+	words := searchWords(item.Title)
+	for _, tag := range item.Tags {
+		words = append(words, searchWords(tag)...)
+	}
+	return words
+}
+
+func intersect(postings map[string]map[linkKey]struct{}, terms []string) map[linkKey]struct{} {
+	if len(terms) == 0 {
+		return nil
+	}
+	slices.SortFunc(terms, func(a, b string) int { return cmp.Compare(len(postings[a]), len(postings[b])) })
+	result := make(map[linkKey]struct{}, len(postings[terms[0]]))
+	for key := range postings[terms[0]] {
+		result[key] = struct{}{}
+	}
+	for _, term := range terms[1:] {
+		for key := range result {
+			if _, ok := postings[term][key]; !ok {
+				delete(result, key)
+			}
+		}
+	}
+	return result
+}
+
+func addPosting(postings map[string]map[linkKey]struct{}, term string, key linkKey) {
+	if postings[term] == nil {
+		postings[term] = make(map[linkKey]struct{})
+	}
+	postings[term][key] = struct{}{}
+}
+
+func removePosting(postings map[string]map[linkKey]struct{}, term string, key linkKey) {
+	delete(postings[term], key)
+	if len(postings[term]) == 0 {
+		delete(postings, term)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shortCodes(page URLPage) []string {
+	var codes []string
+	for _, item := range page.URLs {
+		codes = append(codes, item.ShortURL)
+	}
+	return codes
+}
+
+func TestSearchUserURLs(t *testing.T) {
+	t.Parallel()
+	store := &MemoryStore{Config: config.New(*newTestConfig(t))}
+	for _, item := range []URLStore{
+		{ShortURL: "guide", OriginalURL: "https://docs.example.com/guide", Title: "Getting started guide",
+			Tags: []string{"docs", "onboarding"}},
+		{ShortURL: "api", OriginalURL: "https://docs.example.com/api", Title: "API reference", Tags: []string{"docs"}},
+		{ShortURL: "blog", OriginalURL: "https://blog.example.com/launch", Title: "Launch post"},
+		{ShortURL: "other", OriginalURL: "https://docs.example.com/other", Title: "Someone else's", UserID: "other"},
+	} {
+		if item.UserID == "" {
+			item.UserID = "owner"
+		}
+		_, err := store.Save(context.TODO(), item)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"guide", "api", "blog"}},
+		{"docs", []string{"guide", "api"}},
+		{"Started GUIDE", []string{"guide"}},
+		{"guide api", nil},
+		{"blog.example", []string{"blog"}},
+		{"/api", []string{"api"}},
+		{"ap", []string{"api"}},
+		{"onboarding", []string{"guide"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page, err := store.GetUserURLs(context.TODO(), "owner", URLFilter{Query: tt.query})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, shortCodes(page))
+		})
+	}
+
+	title := "Release notes"
+	_, err := store.UpdateURL(context.TODO(), "", "blog", URLUpdate{Title: &title})
+	require.NoError(t, err)
+	page, err := store.GetUserURLs(context.TODO(), "owner", URLFilter{Query: "release"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blog"}, shortCodes(page))
+	page, err = store.GetUserURLs(context.TODO(), "owner", URLFilter{Query: "launch post"})
+	require.NoError(t, err)
+	assert.Empty(t, page.URLs)
+
+	require.NoError(t, store.DeleteURL(context.TODO(), "", "blog"))
+	assert.NotContains(t, store.index.words, "release")
+}
+
+func TestPaginateUserURLs(t *testing.T) {
+	t.Parallel()
+	store := &MemoryStore{Config: config.New(*newTestConfig(t))}
+	codes := []string{"a", "b", "c", "d", "e"}
+	for _, code := range codes {
+		_, err := store.Save(context.TODO(), URLStore{ShortURL: code, OriginalURL: "https://example.com/" + code,
+			UserID: "owner"})
+		require.NoError(t, err)
+	}
+	for code, clicks := range map[string]int{"c": 3, "a": 2, "e": 2} {
+		for range clicks {
+			require.NoError(t, store.RecordClick(context.TODO(), "", code, AllVariants))
+		}
+	}
+
+	list := func(filter URLFilter) []string {
+		var listed []string
+		for {
+			page, err := store.GetUserURLs(context.TODO(), "owner", filter)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(page.URLs), filter.Limit)
+			listed = append(listed, shortCodes(page)...)
+			if page.NextCursor == "" {
+				return listed
+			}
+			filter.Cursor = page.NextCursor
+		}
+	}
+	assert.Equal(t, codes, list(URLFilter{Limit: 2}))
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, list(URLFilter{Sort: SortCreatedAt, Desc: true, Limit: 2}))
+	assert.Equal(t, []string{"b", "d", "a", "e", "c"}, list(URLFilter{Sort: SortClicks, Limit: 2}))
+	assert.Equal(t, []string{"c", "e", "a", "d", "b"}, list(URLFilter{Sort: SortClicks, Desc: true, Limit: 3}))
+
+	page, err := store.GetUserURLs(context.TODO(), "owner", URLFilter{Sort: SortClicks, Desc: true, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), page.URLs[0].TotalClicks)
+	_, err = store.GetUserURLs(context.TODO(), "owner", URLFilter{Cursor: page.NextCursor, Limit: 1})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = store.GetUserURLs(context.TODO(), "owner", URLFilter{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -5,31 +5,132 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/alexch365/go-url-shortener/internal/logger"
+	"github.com/alexch365/go-url-shortener/internal/rules"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
 	"github.com/alexch365/go-url-shortener/internal/util"
 	"io"
 	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrURLNotFound = errors.New("short URL not found")
+	ErrURLDeleted  = errors.New("short URL has been deleted")
+	ErrURLDisabled = errors.New("short URL has been disabled")
+	ErrCodeTaken   = errors.New("short code is already taken")
 )
 
 type (
 	StoreHandler interface {
-		Initialize() error
-		Get(ctx context.Context, key string) (string, error)
-		Save(ctx context.Context, originalURL string) (string, error)
+		HealthChecker
+		APIKeyStore
+		EventStore
+		ClickStore
+		Initialize(ctx context.Context) error
+		// Short codes are unique per domain, the default domain is "".
+		Get(ctx context.Context, domain, key string) (URLStore, error)
+		Save(ctx context.Context, item URLStore) (URLStore, error)
+		// UseClick disables the link with its last click.
+		UseClick(ctx context.Context, domain, key string) (URLStore, error)
+		// SaveBatch saves nothing when one of the URLs is already stored.
 		SaveBatch(ctx context.Context, store *[]URLStore) ([]URLStore, error)
+		GetUserURLs(ctx context.Context, userID string, filter URLFilter) (URLPage, error)
+		DeleteURLs(ctx context.Context, userID string, keys []string) ([]URLStore, error)
+
+		// The admin methods also see deleted and disabled links.
+		GetURL(ctx context.Context, domain, key string) (URLStore, error)
+		FindURLs(ctx context.Context, originalURL string) ([]URLStore, error)
+		UpdateURL(ctx context.Context, domain, key string, update URLUpdate) (URLStore, error)
+		DeleteURL(ctx context.Context, domain, key string) error
+
+		// ImportURLs skips records whose code or original URL exists.
+		ExportURLs(ctx context.Context, fn func(URLStore) error) error
+		ImportURLs(ctx context.Context, items []URLStore) (int, error)
 	}
+	// URLStore is one link. TotalClicks is only filled in by listings.
 	URLStore struct {
-		UUID          int    `json:"uuid,omitempty" db:"-"`
-		CorrelationID string `json:"correlation_id,omitempty" db:"-"`
-		ShortURL      string `json:"short_url" db:"short_url"`
-		OriginalURL   string `json:"original_url" db:"original_url"`
+		UUID          int             `json:"uuid,omitempty" db:"-"`
+		CorrelationID string          `json:"correlation_id,omitempty" db:"-"`
+		Domain        string          `json:"domain,omitempty" db:"domain"`
+		ShortURL      string          `json:"short_url" db:"short_url"`
+		OriginalURL   string          `json:"original_url" db:"original_url"`
+		UserID        string          `json:"user_id,omitempty" db:"user_id"`
+		DeletedFlag   bool            `json:"is_deleted,omitempty" db:"is_deleted"`
+		Disabled      bool            `json:"is_disabled,omitempty" db:"is_disabled"`
+		Passthrough   string          `json:"passthrough,omitempty" db:"passthrough"`
+		Prefix        bool            `json:"is_prefix,omitempty" db:"is_prefix"`
+		Rules         []rules.Rule    `json:"rules,omitempty" db:"rules"`
+		Split         []rules.Variant `json:"split,omitempty" db:"split"`
+		PasswordHash  string          `json:"password_hash,omitempty" db:"password_hash"`
+		MaxClicks     int             `json:"max_clicks,omitempty" db:"max_clicks"`
+		Clicks        int             `json:"clicks,omitempty" db:"clicks"`
+		Title         string          `json:"title,omitempty" db:"title"`
+		Description   string          `json:"description,omitempty" db:"description"`
+		Tags          []string        `json:"tags,omitempty" db:"tags"`
+		CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+		TotalClicks   int64           `json:"-" db:"-"`
+	}
+	// URLUpdate changes the fields that are set.
+	URLUpdate struct {
+		OriginalURL  *string          `json:"original_url,omitempty"`
+		UserID       *string          `json:"user_id,omitempty"`
+		Disabled     *bool            `json:"disabled,omitempty"`
+		Passthrough  *string          `json:"passthrough,omitempty"`
+		Prefix       *bool            `json:"prefix,omitempty"`
+		Rules        *[]rules.Rule    `json:"-"`
+		Split        *[]rules.Variant `json:"-"`
+		PasswordHash *string          `json:"-"`
+		MaxClicks    *int             `json:"max_clicks,omitempty"`
+		Clicks       *int             `json:"clicks,omitempty"`
+		Title        *string          `json:"title,omitempty"`
+		Description  *string          `json:"description,omitempty"`
+		Tags         *[]string        `json:"tags,omitempty"`
+		Deleted      *bool            `json:"-"`
+	}
+	// URLFilter narrows a user's link listing, zero fields match every link.
+	URLFilter struct {
+		Tag    string
+		Query  string
+		Sort   string
+		Desc   bool
+		Cursor string
+		Limit  int
 	}
 	MemoryStore struct {
-		urls []URLStore
+		Config *config.Source
+
+		mu            sync.RWMutex
+		urls          []URLStore
+		lastUUID      int
+		index         searchIndex
+		events        []Event
+		clicks        map[clickKey]int64
+		pendingClicks map[clickKey]int64
+
+		keysMu   sync.Mutex
+		keys     []APIKey
+		keysStat os.FileInfo
 	}
 )
 
-func (store *MemoryStore) Initialize() error {
-	file, err := os.OpenFile(config.Current.FileStoragePath, os.O_RDONLY, 0666)
+func (store *MemoryStore) Initialize(ctx context.Context) error {
+	if err := store.loadURLs(ctx); err != nil {
+		return err
+	}
+	if err := store.loadEvents(); err != nil {
+		return err
+	}
+	if err := store.loadClicks(); err != nil {
+		return err
+	}
+	return store.loadAPIKeys()
+}
+
+func (store *MemoryStore) loadURLs(ctx context.Context) error {
+	file, err := os.OpenFile(store.Config.Get().FileStoragePath, os.O_RDONLY, 0666)
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -38,6 +139,9 @@ func (store *MemoryStore) Initialize() error {
 	}
 	defer file.Close()
 
+	// A link used by a redirect is appended again, its last line wins.
+	positions := make(map[linkKey]int)
+	stale := 0
 	decoder := json.NewDecoder(file)
 	for {
 		var item URLStore
@@ -46,57 +150,440 @@ func (store *MemoryStore) Initialize() error {
 		} else if err != nil {
 			return err
 		}
+		store.lastUUID = max(store.lastUUID, item.UUID)
+		if i, ok := positions[linkKey{item.Domain, item.ShortURL}]; ok {
+			store.urls[i] = item
+			stale++
+			continue
+		}
+		positions[linkKey{item.Domain, item.ShortURL}] = len(store.urls)
 		store.urls = append(store.urls, item)
 	}
+	for _, item := range store.urls {
+		store.index.add(item)
+	}
+	logger.FromContext(ctx).Infow("file storage loaded", "path", store.Config.Get().FileStoragePath, "size", len(store.urls))
+	if stale > 0 {
+		return writeJSONLines(store.Config.Get().FileStoragePath, store.urls, 0666)
+	}
 	return nil
 }
 
-func (store *MemoryStore) Save(_ context.Context, originalURL string) (string, error) {
-	file, err := os.OpenFile(config.Current.FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return "", err
+func (store *MemoryStore) CheckHealth(ctx context.Context) []ComponentHealth {
+	return []ComponentHealth{
+		checkComponent(ctx, "file_storage", func(_ context.Context) error {
+			file, err := os.OpenFile(store.Config.Get().FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+			if err != nil {
+				return err
+			}
+			return file.Close()
+		}),
 	}
-	defer file.Close()
+}
+
+func (store *MemoryStore) Save(ctx context.Context, item URLStore) (_ URLStore, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.Save")
+	defer func() { tracing.End(span, err) }()
 
-	urlStore := URLStore{UUID: len(store.urls), ShortURL: util.RandomString(8), OriginalURL: originalURL}
-	store.urls = append(store.urls, urlStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
-	err = json.NewEncoder(file).Encode(urlStore)
+	file, err := os.OpenFile(store.Config.Get().FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		return "", err
+		return URLStore{}, err
+	}
+	defer file.Close()
+
+	if i := store.indexOfOriginal(item.Domain, item.OriginalURL); i >= 0 {
+		logger.FromContext(ctx).Debugw("original url already stored", "url", item.OriginalURL,
+			"short_url", store.urls[i].ShortURL)
+		return URLStore{}, ConflictError{URL: store.urls[i]}
+	}
+	if item.ShortURL == "" {
+		item.ShortURL = util.RandomString(8)
+	} else if store.indexOf(item.Domain, item.ShortURL) >= 0 {
+		return URLStore{}, ErrCodeTaken
 	}
-	return config.Current.BaseURL + "/" + urlStore.ShortURL, nil
+	item.UUID = store.lastUUID + 1
+	item.CreatedAt = time.Now().UTC()
+	if err = json.NewEncoder(file).Encode(item); err != nil {
+		return URLStore{}, err
+	}
+	store.lastUUID = item.UUID
+	store.urls = append(store.urls, item)
+	store.index.add(item)
+	logger.FromContext(ctx).Debugw("url saved", "short_url", item.ShortURL)
+	return item, nil
 }
 
-func (store *MemoryStore) SaveBatch(_ context.Context, urlStore *[]URLStore) ([]URLStore, error) {
-	file, err := os.OpenFile(config.Current.FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+func (store *MemoryStore) SaveBatch(ctx context.Context, urlStore *[]URLStore) (_ []URLStore, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.SaveBatch")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.OpenFile(store.Config.Get().FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	for _, item := range *urlStore {
+		if i := store.indexOfOriginal(item.Domain, item.OriginalURL); i >= 0 {
+			return nil, ConflictError{URL: store.urls[i]}
+		}
+	}
+
 	encoder := json.NewEncoder(file)
 	var resultURLs []URLStore
+	saved := make(map[[2]string]string, len(*urlStore))
 	for _, item := range *urlStore {
+		if shortURL, ok := saved[[2]string{item.Domain, item.OriginalURL}]; ok {
+			item.ShortURL = shortURL
+			resultURLs = append(resultURLs, item)
+			continue
+		}
 		item.ShortURL = util.RandomString(8)
-		store.urls = append(store.urls, item)
-		resultItem := item
-		resultItem.ShortURL = config.Current.BaseURL + "/" + item.ShortURL
-		resultURLs = append(resultURLs, resultItem)
-
+		item.UUID = store.lastUUID + 1
+		item.CreatedAt = time.Now().UTC()
 		if err = encoder.Encode(item); err != nil {
 			return resultURLs, err
 		}
+		store.lastUUID = item.UUID
+		store.urls = append(store.urls, item)
+		store.index.add(item)
+		resultURLs = append(resultURLs, item)
+		saved[[2]string{item.Domain, item.OriginalURL}] = item.ShortURL
 	}
 
 	return resultURLs, nil
 }
 
-func (store *MemoryStore) Get(_ context.Context, key string) (string, error) {
+func (store *MemoryStore) Get(ctx context.Context, domain, key string) (URLStore, error) {
+	_, span := tracing.Start(ctx, "MemoryStore.Get")
+	defer span.End()
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	i := store.indexOf(domain, key)
+	switch {
+	case i < 0:
+		return URLStore{}, ErrURLNotFound
+	case store.urls[i].DeletedFlag:
+		return URLStore{}, ErrURLDeleted
+	case store.urls[i].Disabled:
+		return URLStore{}, ErrURLDisabled
+	}
+	return store.urls[i], nil
+}
+
+func (store *MemoryStore) UseClick(ctx context.Context, domain, key string) (_ URLStore, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.UseClick")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	i := store.indexOf(domain, key)
+	switch {
+	case i < 0:
+		return URLStore{}, ErrURLNotFound
+	case store.urls[i].DeletedFlag:
+		return URLStore{}, ErrURLDeleted
+	case store.urls[i].Disabled || store.urls[i].MaxClicks > 0 && store.urls[i].Clicks >= store.urls[i].MaxClicks:
+		return URLStore{}, ErrURLDisabled
+	}
+	item := store.urls[i]
+	item.Clicks++
+	if item.MaxClicks > 0 && item.Clicks >= item.MaxClicks {
+		item.Disabled = true
+	}
+
+	file, err := os.OpenFile(store.Config.Get().FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return URLStore{}, err
+	}
+	defer file.Close()
+	if err = json.NewEncoder(file).Encode(item); err != nil {
+		return URLStore{}, err
+	}
+	store.urls[i] = item
+	return item, nil
+}
+
+func (store *MemoryStore) GetUserURLs(ctx context.Context, userID string, filter URLFilter) (_ URLPage, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.GetUserURLs")
+	defer func() { tracing.End(span, err) }()
+
+	c, err := filter.decodeCursor()
+	if err != nil {
+		return URLPage{}, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var candidates map[linkKey]struct{}
+	indexed := false
+	if filter.Query != "" {
+		candidates, indexed = store.index.candidates(filter.Query)
+	}
+	var result []URLStore
+	for _, item := range store.urls {
+		if item.UserID != userID || item.DeletedFlag || !filter.matches(item) {
+			continue
+		}
+		if indexed {
+			if _, ok := candidates[linkKey{item.Domain, item.ShortURL}]; !ok {
+				continue
+			}
+		}
+		if filter.Query != "" && !searchMatches(item, filter.Query) {
+			continue
+		}
+		result = append(result, URLStore{
+			Domain:      item.Domain,
+			ShortURL:    item.ShortURL,
+			OriginalURL: item.OriginalURL,
+			Split:       item.Split,
+			Title:       item.Title,
+			Description: item.Description,
+			Tags:        item.Tags,
+			CreatedAt:   item.CreatedAt,
+			TotalClicks: store.clicks[clickKey{item.Domain, item.ShortURL, AllVariants}],
+		})
+	}
+	return filter.page(result, c), nil
+}
+
+func (store *MemoryStore) DeleteURLs(ctx context.Context, userID string, keys []string) (_ []URLStore, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.DeleteURLs")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var deleted []URLStore
 	for i := range store.urls {
-		if store.urls[i].ShortURL == key {
-			return store.urls[i].OriginalURL, nil
+		if store.urls[i].UserID == userID && !store.urls[i].DeletedFlag && slices.Contains(keys, store.urls[i].ShortURL) {
+			store.urls[i].DeletedFlag = true
+			deleted = append(deleted, store.urls[i])
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+	logger.FromContext(ctx).Debugw("urls deleted", "user_id", userID, "count", len(deleted))
+	return deleted, writeJSONLines(store.Config.Get().FileStoragePath, store.urls, 0666)
+}
+
+func (store *MemoryStore) GetURL(ctx context.Context, domain, key string) (URLStore, error) {
+	_, span := tracing.Start(ctx, "MemoryStore.GetURL")
+	defer span.End()
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if i := store.indexOf(domain, key); i >= 0 {
+		return store.urls[i], nil
+	}
+	return URLStore{}, ErrURLNotFound
+}
+
+func (store *MemoryStore) FindURLs(ctx context.Context, originalURL string) ([]URLStore, error) {
+	_, span := tracing.Start(ctx, "MemoryStore.FindURLs")
+	defer span.End()
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var result []URLStore
+	for _, item := range store.urls {
+		if item.OriginalURL == originalURL {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func (store *MemoryStore) UpdateURL(ctx context.Context, domain, key string, update URLUpdate) (_ URLStore, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.UpdateURL")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	i := store.indexOf(domain, key)
+	if i < 0 {
+		return URLStore{}, ErrURLNotFound
+	}
+	if update.OriginalURL != nil {
+		if j := store.indexOfOriginal(domain, *update.OriginalURL); j >= 0 && j != i {
+			return URLStore{}, ConflictError{URL: store.urls[j]}
+		}
+	}
+	store.index.remove(store.urls[i])
+	defer func() { store.index.add(store.urls[i]) }()
+	if update.OriginalURL != nil {
+		store.urls[i].OriginalURL = *update.OriginalURL
+	}
+	if update.UserID != nil {
+		store.urls[i].UserID = *update.UserID
+	}
+	if update.Disabled != nil {
+		store.urls[i].Disabled = *update.Disabled
+	}
+	if update.Passthrough != nil {
+		store.urls[i].Passthrough = *update.Passthrough
+	}
+	if update.Prefix != nil {
+		store.urls[i].Prefix = *update.Prefix
+	}
+	if update.Rules != nil {
+		store.urls[i].Rules = *update.Rules
+	}
+	if update.Split != nil {
+		store.urls[i].Split = *update.Split
+	}
+	if update.PasswordHash != nil {
+		store.urls[i].PasswordHash = *update.PasswordHash
+	}
+	if update.MaxClicks != nil {
+		store.urls[i].MaxClicks = *update.MaxClicks
+	}
+	if update.Clicks != nil {
+		store.urls[i].Clicks = *update.Clicks
+	}
+	if update.Title != nil {
+		store.urls[i].Title = *update.Title
+	}
+	if update.Description != nil {
+		store.urls[i].Description = *update.Description
+	}
+	if update.Tags != nil {
+		store.urls[i].Tags = *update.Tags
+	}
+	if update.Deleted != nil {
+		store.urls[i].DeletedFlag = *update.Deleted
+	}
+	return store.urls[i], writeJSONLines(store.Config.Get().FileStoragePath, store.urls, 0666)
+}
+
+func (store *MemoryStore) DeleteURL(ctx context.Context, domain, key string) (err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.DeleteURL")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	i := store.indexOf(domain, key)
+	if i < 0 {
+		return ErrURLNotFound
+	}
+	store.index.remove(store.urls[i])
+	store.urls = slices.Delete(store.urls, i, i+1)
+	if err = writeJSONLines(store.Config.Get().FileStoragePath, store.urls, 0666); err != nil {
+		return err
+	}
+	return store.dropClicks(domain, key)
+}
+
+func (store *MemoryStore) ExportURLs(ctx context.Context, fn func(URLStore) error) (err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.ExportURLs")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.RLock()
+	urls := slices.Clone(store.urls)
+	store.mu.RUnlock()
+
+	for _, item := range urls {
+		if err = fn(item); err != nil {
+			return err
 		}
 	}
-	return "", errors.New("key not found")
+	return nil
+}
+
+func (store *MemoryStore) ImportURLs(ctx context.Context, items []URLStore) (_ int, err error) {
+	_, span := tracing.Start(ctx, "MemoryStore.ImportURLs")
+	defer func() { tracing.End(span, err) }()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.OpenFile(store.Config.Get().FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	codes := make(map[[2]string]struct{}, len(store.urls))
+	originals := make(map[[2]string]struct{}, len(store.urls))
+	for _, item := range store.urls {
+		codes[[2]string{item.Domain, item.ShortURL}] = struct{}{}
+		originals[[2]string{item.Domain, item.OriginalURL}] = struct{}{}
+	}
+
+	encoder := json.NewEncoder(file)
+	imported := 0
+	for _, item := range items {
+		if _, ok := codes[[2]string{item.Domain, item.ShortURL}]; ok {
+			continue
+		}
+		if _, ok := originals[[2]string{item.Domain, item.OriginalURL}]; ok {
+			continue
+		}
+		item.UUID = store.lastUUID + 1
+		if item.CreatedAt.IsZero() {
+			item.CreatedAt = time.Now().UTC()
+		}
+		if err = encoder.Encode(item); err != nil {
+			return imported, err
+		}
+		store.lastUUID = item.UUID
+		store.urls = append(store.urls, item)
+		store.index.add(item)
+		codes[[2]string{item.Domain, item.ShortURL}] = struct{}{}
+		originals[[2]string{item.Domain, item.OriginalURL}] = struct{}{}
+		imported++
+	}
+	return imported, nil
+}
+
+func (filter URLFilter) matches(item URLStore) bool {
+	return filter.Tag == "" || slices.Contains(item.Tags, filter.Tag)
+}
+
+func (store *MemoryStore) indexOf(domain, key string) int {
+	return slices.IndexFunc(store.urls, func(item URLStore) bool {
+		return item.Domain == domain && item.ShortURL == key
+	})
+}
+
+func (store *MemoryStore) indexOfOriginal(domain, originalURL string) int {
+	return slices.IndexFunc(store.urls, func(item URLStore) bool {
+		return item.Domain == domain && item.OriginalURL == originalURL
+	})
+}
+
+func writeJSONLines[T any](path string, items []T, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, item := range items {
+		if err = encoder.Encode(item); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreUseClick(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	store := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, store.Initialize(context.TODO()))
+	_, err := store.Save(context.TODO(), URLStore{ShortURL: "once", OriginalURL: "https://go.dev", MaxClicks: 2})
+	require.NoError(t, err)
+	_, err = store.Save(context.TODO(), URLStore{ShortURL: "other", OriginalURL: "https://go.dev/doc"})
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err = store.UseClick(context.TODO(), "", "once")
+		require.NoError(t, err)
+	}
+	_, err = store.UseClick(context.TODO(), "", "once")
+	assert.ErrorIs(t, err, ErrURLDisabled)
+
+	reopened := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, reopened.Initialize(context.TODO()))
+	item, err := reopened.GetURL(context.TODO(), "", "once")
+	require.NoError(t, err)
+	assert.Equal(t, 2, item.Clicks)
+	assert.True(t, item.Disabled)
+
+	content, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"), "loading compacts the file")
+}
+
+func TestMemoryStoreUUIDs(t *testing.T) {
+	t.Parallel()
+	cfg := newTestConfig(t)
+	store := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, store.Initialize(context.TODO()))
+	first, err := store.Save(context.TODO(), URLStore{ShortURL: "first", OriginalURL: "https://go.dev"})
+	require.NoError(t, err)
+	second, err := store.Save(context.TODO(), URLStore{ShortURL: "second", OriginalURL: "https://go.dev/doc"})
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteURL(context.TODO(), "", first.ShortURL))
+
+	third, err := store.Save(context.TODO(), URLStore{ShortURL: "third", OriginalURL: "https://go.dev/blog"})
+	require.NoError(t, err)
+	assert.Greater(t, third.UUID, second.UUID)
+
+	reopened := &MemoryStore{Config: config.New(*cfg)}
+	require.NoError(t, reopened.Initialize(context.TODO()))
+	fourth, err := reopened.Save(context.TODO(), URLStore{ShortURL: "fourth", OriginalURL: "https://go.dev/play"})
+	require.NoError(t, err)
+	assert.Greater(t, fourth.UUID, third.UUID)
+}
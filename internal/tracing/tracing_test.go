@@ -0,0 +1,67 @@
+package tracing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexch365/go-url-shortener/internal/storage"
+	"github.com/alexch365/go-url-shortener/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	store := &storage.MemoryStore{}
+	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, req *http.Request) {
+		_, err := store.Get(req.Context(), "", chi.URLParam(req, "id"))
+		assert.Error(t, err)
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/abcdefgh", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	storeSpan, serverSpan := spans[0], spans[1]
+
+	assert.Equal(t, "GET /{id}", serverSpan.Name)
+	assert.Equal(t, trace.SpanKindServer, serverSpan.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent.SpanID().String())
+
+	assert.Equal(t, "MemoryStore.Get", storeSpan.Name)
+	assert.Equal(t, serverSpan.SpanContext.SpanID(), storeSpan.Parent.SpanID())
+}
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"with bind variables", "SELECT original_url\n\t\tFROM urls WHERE short_url = $1", "SELECT original_url FROM urls WHERE short_url = $1"},
+		{"with string literal", "SELECT id FROM urls WHERE short_url = 'abc''def'", "SELECT id FROM urls WHERE short_url = ?"},
+		{"with numeric literal", "SELECT id FROM urls WHERE id > 42 LIMIT 10", "SELECT id FROM urls WHERE id > ? LIMIT ?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tracing.SanitizeSQL(tt.query))
+		})
+	}
+}
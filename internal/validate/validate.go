@@ -0,0 +1,79 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrBlocked      = errors.New("host is blocked")
+	ErrInvalidCode  = errors.New("short code must be 1 to 64 letters, digits, dashes or underscores")
+	ErrReservedCode = errors.New("short code is reserved")
+
+	codePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	// reservedCodes are taken by the fixed routes.
+	reservedCodes = []string{"api", "ping", "healthz", "readyz"}
+)
+
+type Validator struct {
+	blocklist atomic.Pointer[map[string]struct{}]
+}
+
+func New(blocklist []string) *Validator {
+	validator := &Validator{}
+	validator.SetBlocklist(blocklist)
+	return validator
+}
+
+func (v *Validator) URL(raw string) error {
+	parsed, err := url.ParseRequestURI(raw)
+	if err != nil {
+		return err
+	}
+	if v.Blocked(parsed.Hostname()) {
+		return fmt.Errorf("%w: %s", ErrBlocked, parsed.Hostname())
+	}
+	return nil
+}
+
+func (v *Validator) Blocked(host string) bool {
+	blocked := *v.blocklist.Load()
+	if len(blocked) == 0 {
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for host != "" {
+		if _, ok := blocked[host]; ok {
+			return true
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return false
+}
+
+// SetBlocklist blocks subdomains too, *.example.com means example.com.
+func (v *Validator) SetBlocklist(entries []string) {
+	blocked := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(entry)), "*.")
+		if entry = strings.Trim(entry, "."); entry != "" {
+			blocked[entry] = struct{}{}
+		}
+	}
+	v.blocklist.Store(&blocked)
+}
+
+func Code(code string) error {
+	if !codePattern.MatchString(code) {
+		return ErrInvalidCode
+	}
+	if slices.Contains(reservedCodes, code) {
+		return fmt.Errorf("%w: %s", ErrReservedCode, code)
+	}
+	return nil
+}
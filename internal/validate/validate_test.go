@@ -0,0 +1,48 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURL(t *testing.T) {
+	t.Parallel()
+	validator := New([]string{"evil.com", "*.Phish.example."})
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+		blocked bool
+	}{
+		{name: "allowed", url: "https://example.com/path"},
+		{name: "not a URL", url: "example", wantErr: true},
+		{name: "blocked host", url: "http://evil.com", wantErr: true, blocked: true},
+		{name: "blocked subdomain", url: "https://login.EVIL.com/x", wantErr: true, blocked: true},
+		{name: "wildcard entry", url: "https://a.b.phish.example", wantErr: true, blocked: true},
+		{name: "similar name", url: "https://notevil.com", wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.URL(tt.url)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Equal(t, tt.blocked, errors.Is(err, ErrBlocked))
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, Code("Docs_2024-q1"))
+	assert.ErrorIs(t, Code(""), ErrInvalidCode)
+	assert.ErrorIs(t, Code("a/b"), ErrInvalidCode)
+	assert.ErrorIs(t, Code(strings.Repeat("a", 65)), ErrInvalidCode)
+	assert.ErrorIs(t, Code("healthz"), ErrReservedCode)
+}